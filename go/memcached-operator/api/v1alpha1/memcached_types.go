@@ -17,7 +17,10 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -29,15 +32,314 @@ type MemcachedSpec struct {
 	// Important: Run "make" to regenerate code after modifying this file
 
 	Size int32 `json:"size"`
+
+	// Memory is the memory memcached may use for items in each pod, passed
+	// with -m. Defaults to 64Mi.
+	// +optional
+	Memory *resource.Quantity `json:"memory,omitempty"`
+
+	// WarmUp configures replaying the cache contents into the pods created
+	// when the operator rolls the Deployment.
+	// +optional
+	WarmUp *WarmUpSpec `json:"warmUp,omitempty"`
+
+	// Router deploys mcrouter in front of the memcached pods.
+	// +optional
+	Router *RouterSpec `json:"router,omitempty"`
+
+	// Autoscaling lets the operator change Size from the statistics reported
+	// by the memcached pods.
+	// +optional
+	Autoscaling *AutoscalingSpec `json:"autoscaling,omitempty"`
+
+	// ConfigRef names a ConfigMap of the namespace holding extra memcached
+	// options, one per key such as threads: "8". The options are passed
+	// after those set from the spec, and the pods are rolled when the
+	// ConfigMap changes.
+	// +optional
+	ConfigRef *corev1.LocalObjectReference `json:"configRef,omitempty"`
+
+	// PodTemplate is a strategic merge patch of the pod template of the
+	// memcached Deployment, as an escape hatch for the fields the spec does
+	// not model such as annotations, tolerations or sidecars. The memcached
+	// container is customized by naming it memcached. The selector labels
+	// and the memcached command are set by the operator and cannot be
+	// overridden.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	PodTemplate *runtime.RawExtension `json:"podTemplate,omitempty"`
+
+	// SecurityProfile is the security context of the memcached pods.
+	// Defaults to Restricted.
+	// +optional
+	SecurityProfile SecurityProfile `json:"securityProfile,omitempty"`
+
+	// ClassName names the MemcachedClass whose presets apply to what the
+	// spec leaves unset. Defaults to the class with the
+	// cache.example.com/is-default-class annotation, if any.
+	// +optional
+	ClassName string `json:"className,omitempty"`
 }
 
+// SecurityProfile is the security context the memcached pods run with
+// +kubebuilder:validation:Enum=Restricted;Unrestricted
+type SecurityProfile string
+
+const (
+	// SecurityProfileRestricted runs memcached as a non-root user with a
+	// read-only root filesystem, no capabilities, the runtime default seccomp
+	// profile and no service account token, following the restricted Pod
+	// Security Standard.
+	SecurityProfileRestricted SecurityProfile = "Restricted"
+	// SecurityProfileUnrestricted runs memcached with the defaults of its
+	// image and of the namespace.
+	SecurityProfileUnrestricted SecurityProfile = "Unrestricted"
+)
+
+// WarmUpSpec defines how pods are warmed up after a rollout
+type WarmUpSpec struct {
+	// Enabled turns on the key snapshot before a rollout and the replay into
+	// the new pods once they are ready.
+	Enabled bool `json:"enabled"`
+
+	// MaxKeys limits the number of keys captured in the snapshot. Defaults to 10000.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxKeys int32 `json:"maxKeys,omitempty"`
+}
+
+// RouterMode is the routing policy mcrouter applies to the memcached pods
+// +kubebuilder:validation:Enum=Sharded;Replicated
+type RouterMode string
+
+const (
+	// RouterModeSharded spreads the keys over the pods with consistent hashing.
+	RouterModeSharded RouterMode = "Sharded"
+	// RouterModeReplicated writes every key to all pods and fails reads over
+	// between them.
+	RouterModeReplicated RouterMode = "Replicated"
+)
+
+// RouterSpec defines the mcrouter front-end of a Memcached
+type RouterSpec struct {
+	// Enabled deploys mcrouter. Disabling it removes the mcrouter resources.
+	Enabled bool `json:"enabled"`
+
+	// Mode is the routing policy. Defaults to Sharded.
+	// +optional
+	Mode RouterMode `json:"mode,omitempty"`
+
+	// Replicas is the number of mcrouter pods. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Image is the mcrouter image. Defaults to mcrouter/mcrouter:v0.41.0.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// AutoscalingSpec defines the bounds and targets of the autoscaler
+type AutoscalingSpec struct {
+	// MinReplicas is the lowest Size the autoscaler may set. Must be odd.
+	// +kubebuilder:validation:Minimum=1
+	MinReplicas int32 `json:"minReplicas"`
+	// MaxReplicas is the highest Size the autoscaler may set. Must be odd.
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// TargetHitRatio is the percentage of gets that should hit the cache.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	TargetHitRatio *int32 `json:"targetHitRatio,omitempty"`
+	// TargetMemoryUtilization is the percentage of the memory limit each pod should use.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	TargetMemoryUtilization *int32 `json:"targetMemoryUtilization,omitempty"`
+	// TargetConnections is the number of client connections each pod should serve.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TargetConnections *int32 `json:"targetConnections,omitempty"`
+
+	// ScaleUpStabilizationSeconds is the window over which the lowest
+	// recommendation is used when scaling up. Defaults to 60.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	ScaleUpStabilizationSeconds *int32 `json:"scaleUpStabilizationSeconds,omitempty"`
+	// ScaleDownStabilizationSeconds is the window over which the highest
+	// recommendation is used when scaling down. Defaults to 300.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	ScaleDownStabilizationSeconds *int32 `json:"scaleDownStabilizationSeconds,omitempty"`
+	// CooldownSeconds is the minimum time between two scaling operations. Defaults to 120.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	CooldownSeconds *int32 `json:"cooldownSeconds,omitempty"`
+
+	// Vertical controls whether the memory and replica recommendations
+	// published in status are applied. Off only publishes them; Auto also
+	// updates Memory, and Size when no horizontal target is set. Defaults to Off.
+	// +optional
+	Vertical VerticalMode `json:"vertical,omitempty"`
+}
+
+// VerticalMode is how the sizing recommendations are used
+// +kubebuilder:validation:Enum=Off;Auto
+type VerticalMode string
+
+const (
+	// VerticalModeOff only publishes the recommendations in status.
+	VerticalModeOff VerticalMode = "Off"
+	// VerticalModeAuto applies the recommendations to the spec.
+	VerticalModeAuto VerticalMode = "Auto"
+)
+
 // MemcachedStatus defines the observed state of Memcached
 type MemcachedStatus struct {
 	Nodes []string `json:"nodes"`
+
+	// WarmUp reports the progress of the last cache warm-up.
+	// +optional
+	WarmUp *WarmUpStatus `json:"warmUp,omitempty"`
+
+	// Router reports the pool mcrouter is currently configured with.
+	// +optional
+	Router *RouterStatus `json:"router,omitempty"`
+
+	// Autoscaling reports the metrics and decisions of the autoscaler.
+	// +optional
+	Autoscaling *AutoscalingStatus `json:"autoscaling,omitempty"`
+
+	// Recommendation is the memory and replica count suggested by the
+	// statistics observed over the last hour.
+	// +optional
+	Recommendation *RecommendationStatus `json:"recommendation,omitempty"`
+
+	// Plan lists the changes the operator would make to the objects of the
+	// Memcached, while it runs in dry-run mode.
+	// +optional
+	Plan []PlannedChange `json:"plan,omitempty"`
+
+	// Class reports the MemcachedClass applied to the pods.
+	// +optional
+	Class *ClassStatus `json:"class,omitempty"`
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 }
 
+// WarmUpPhase is the stage a cache warm-up is in
+type WarmUpPhase string
+
+const (
+	// WarmUpPhaseWarming means a snapshot was taken and new pods are being warmed.
+	WarmUpPhaseWarming WarmUpPhase = "Warming"
+	// WarmUpPhaseCompleted means every new pod received the snapshot.
+	WarmUpPhaseCompleted WarmUpPhase = "Completed"
+	// WarmUpPhaseFailed means no snapshot could be taken before the rollout.
+	WarmUpPhaseFailed WarmUpPhase = "Failed"
+)
+
+// WarmUpStatus defines the observed state of a cache warm-up
+type WarmUpStatus struct {
+	Phase WarmUpPhase `json:"phase"`
+	// SnapshotKeys is the number of keys captured before the rollout.
+	SnapshotKeys int32 `json:"snapshotKeys"`
+	// WarmedKeys is the number of keys replayed into new pods.
+	WarmedKeys int32 `json:"warmedKeys"`
+	// SkippedKeys is the number of keys that expired or that no peer held any more.
+	SkippedKeys int32 `json:"skippedKeys"`
+	// WarmedPods are the pods that received the snapshot.
+	// +optional
+	WarmedPods []string `json:"warmedPods,omitempty"`
+	// Message describes why the warm-up failed.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// ClassStatus defines the MemcachedClass a Memcached was reconciled with
+type ClassStatus struct {
+	// Name is the name of the class.
+	Name string `json:"name"`
+	// Revision is the generation of the class that was applied.
+	Revision int64 `json:"revision"`
+}
+
+// RouterStatus defines the observed state of the mcrouter front-end
+type RouterStatus struct {
+	// Pool lists the addresses of the ready memcached pods mcrouter routes to.
+	Pool []string `json:"pool"`
+}
+
+// AutoscalingStatus defines the observed state of the autoscaler
+type AutoscalingStatus struct {
+	// CurrentHitRatio is the percentage of gets that hit since the previous poll.
+	// +optional
+	CurrentHitRatio *int32 `json:"currentHitRatio,omitempty"`
+	// CurrentMemoryUtilization is the percentage of the memory limit in use.
+	// +optional
+	CurrentMemoryUtilization *int32 `json:"currentMemoryUtilization,omitempty"`
+	// CurrentConnections is the average number of connections per pod.
+	// +optional
+	CurrentConnections *int32 `json:"currentConnections,omitempty"`
+	// DesiredReplicas is the last stabilized recommendation.
+	DesiredReplicas int32 `json:"desiredReplicas"`
+	// LastScaleTime is when the autoscaler last changed Size or memory.
+	// +optional
+	LastScaleTime *metav1.Time `json:"lastScaleTime,omitempty"`
+	// History lists the latest scaling operations, most recent last.
+	// +optional
+	History []ScalingEvent `json:"history,omitempty"`
+}
+
+// ScalingEvent records a change of Size made by the autoscaler
+type ScalingEvent struct {
+	Time   metav1.Time `json:"time"`
+	From   int32       `json:"from"`
+	To     int32       `json:"to"`
+	Reason string      `json:"reason"`
+}
+
+// RecommendationStatus defines the sizing suggested for a Memcached
+type RecommendationStatus struct {
+	// Memory is the recommended memory of each pod.
+	Memory resource.Quantity `json:"memory"`
+	// Replicas is the recommended Size.
+	Replicas int32 `json:"replicas"`
+	// Reason explains the recommendation.
+	Reason string `json:"reason"`
+	// LastUpdateTime is when the recommendation last changed.
+	LastUpdateTime metav1.Time `json:"lastUpdateTime"`
+}
+
+// DryRunAnnotation set to "true" on a Memcached makes the operator report
+// the changes it would make to the objects of the Memcached in status and
+// events instead of making them.
+const DryRunAnnotation = "cache.example.com/dry-run"
+
+const (
+	// AppLabel is set on the objects of a Memcached, holding the component
+	// they belong to: memcached or mcrouter.
+	AppLabel = "app"
+
+	// MemcachedLabel is set on the objects of a Memcached and of its mcrouter
+	// front-end, holding the name of the Memcached.
+	MemcachedLabel = "memcached_cr"
+)
+
+// PlannedChange is a change the operator would make in dry-run mode
+type PlannedChange struct {
+	// Action is either create, update or delete.
+	Action string `json:"action"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	// Fields lists the fields an update would change, with their current
+	// and new values.
+	// +optional
+	Fields []string `json:"fields,omitempty"`
+}
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 
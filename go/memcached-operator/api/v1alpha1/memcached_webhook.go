@@ -17,7 +17,13 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -41,7 +47,7 @@ var _ webhook.Defaulter = &Memcached{}
 
 // Default implements webhook.Defaulter so a webhook will be registered for the type
 func (r *Memcached) Default() {
-	memcachedlog.Info("default", "name", r.Name)
+	memcachedlog.Info("default", "memcached", r.Name, "namespace", r.Namespace)
 
 	if r.Spec.Size == 0 {
 		r.Spec.Size = 3
@@ -55,27 +61,168 @@ var _ webhook.Validator = &Memcached{}
 
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type
 func (r *Memcached) ValidateCreate() error {
-	memcachedlog.Info("validate create", "name", r.Name)
+	memcachedlog.Info("validate create", "memcached", r.Name, "namespace", r.Namespace)
 
-	return validateOdd(r.Spec.Size)
+	return r.validate()
 }
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
 func (r *Memcached) ValidateUpdate(old runtime.Object) error {
-	memcachedlog.Info("validate update", "name", r.Name)
+	memcachedlog.Info("validate update", "memcached", r.Name, "namespace", r.Namespace)
 
-	return validateOdd(r.Spec.Size)
+	return r.validate()
 }
 
 // ValidateDelete implements webhook.Validator so a webhook will be registered for the type
 func (r *Memcached) ValidateDelete() error {
-	memcachedlog.Info("validate delete", "name", r.Name)
+	memcachedlog.Info("validate delete", "memcached", r.Name, "namespace", r.Namespace)
+
+	return nil
+}
+
+// validate checks the rules the API schema cannot express
+func (r *Memcached) validate() error {
+	if err := validateOdd(r.Spec.Size); err != nil {
+		return err
+	}
+	if r.Spec.Memory != nil && r.Spec.Memory.Sign() <= 0 {
+		return errors.New("Memory must be a positive quantity")
+	}
+	if r.Spec.ConfigRef != nil && r.Spec.ConfigRef.Name == "" {
+		return errors.New("ConfigRef must name a ConfigMap")
+	}
+	if err := validatePodTemplate(r.Spec.PodTemplate); err != nil {
+		return err
+	}
+	return validateAutoscaling(r.Spec.Autoscaling)
+}
+
+// selectorLabels are the labels the memcached Deployment selects its pods
+// by, set by resources.MemcachedLabels.
+var selectorLabels = []string{AppLabel, MemcachedLabel}
+
+// validatePodTemplate checks that the podTemplate patch is a pod template,
+// once its patch directives are left out, that does not override the fields
+// set by the operator or remove them with a directive.
+func validatePodTemplate(raw *runtime.RawExtension) error {
+	if raw == nil {
+		return nil
+	}
+	var patch interface{}
+	if err := json.Unmarshal(raw.Raw, &patch); err != nil {
+		return fmt.Errorf("PodTemplate is not a pod template: %v", err)
+	}
+	if err := validateDirectives(patch); err != nil {
+		return err
+	}
+	data, err := json.Marshal(withoutDirectives(patch))
+	if err != nil {
+		return err
+	}
+	template := &corev1.PodTemplateSpec{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(template); err != nil {
+		return fmt.Errorf("PodTemplate is not a pod template: %v", err)
+	}
+
+	for _, label := range selectorLabels {
+		if _, ok := template.Labels[label]; ok {
+			return fmt.Errorf("PodTemplate cannot override the selector label %s", label)
+		}
+	}
+	for _, c := range template.Spec.Containers {
+		if c.Name == "memcached" && (len(c.Command) > 0 || len(c.Args) > 0) {
+			return errors.New("PodTemplate cannot override the command of the memcached container, use configRef for extra options")
+		}
+	}
+	return nil
+}
 
+// validateDirectives checks that the patch directives of a podTemplate patch
+// cannot remove the selector labels or the memcached container, which the
+// pod template would then be rendered without. Directives elsewhere, such as
+// replacing the tolerations or deleting a sidecar, are allowed.
+func validateDirectives(patch interface{}) error {
+	template, _ := patch.(map[string]interface{})
+	metadata, _ := template["metadata"].(map[string]interface{})
+	spec, _ := template["spec"].(map[string]interface{})
+	for _, obj := range []struct {
+		path   string
+		fields map[string]interface{}
+	}{{"the pod template", template}, {"metadata", metadata}, {"spec", spec}} {
+		for _, directive := range []string{"$patch", "$retainKeys"} {
+			if _, ok := obj.fields[directive]; ok {
+				return fmt.Errorf("PodTemplate cannot use the %s directive on %s", directive, obj.path)
+			}
+		}
+	}
+	labels, _ := metadata["labels"].(map[string]interface{})
+	for k := range labels {
+		if strings.HasPrefix(k, "$") {
+			return fmt.Errorf("PodTemplate cannot use the %s directive on the labels", k)
+		}
+	}
+	for k := range spec {
+		if strings.HasPrefix(k, "$") && strings.HasSuffix(k, "/containers") {
+			return fmt.Errorf("PodTemplate cannot use the %s directive on the containers", k)
+		}
+	}
+	containers, _ := spec["containers"].([]interface{})
+	for _, item := range containers {
+		c, _ := item.(map[string]interface{})
+		name, _ := c["name"].(string)
+		for k := range c {
+			if !strings.HasPrefix(k, "$") {
+				continue
+			}
+			if name == "" {
+				return fmt.Errorf("PodTemplate cannot use the %s directive on the containers", k)
+			}
+			if name == "memcached" {
+				return fmt.Errorf("PodTemplate cannot use the %s directive on the memcached container", k)
+			}
+		}
+	}
 	return nil
 }
+
+// withoutDirectives returns v without the keys of strategic merge patch
+// directives, such as $patch or $setElementOrder/containers.
+func withoutDirectives(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, item := range t {
+			if strings.HasPrefix(k, "$") {
+				delete(t, k)
+			} else {
+				t[k] = withoutDirectives(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range t {
+			t[i] = withoutDirectives(item)
+		}
+	}
+	return v
+}
+
 func validateOdd(n int32) error {
 	if n%2 == 0 {
 		return errors.New("Cluster size must be an odd number")
 	}
 	return nil
 }
+
+func validateAutoscaling(a *AutoscalingSpec) error {
+	if a == nil {
+		return nil
+	}
+	if a.MinReplicas%2 == 0 || a.MaxReplicas%2 == 0 {
+		return errors.New("Autoscaling minReplicas and maxReplicas must be odd numbers")
+	}
+	if a.MinReplicas > a.MaxReplicas {
+		return errors.New("Autoscaling minReplicas must not be greater than maxReplicas")
+	}
+	return nil
+}
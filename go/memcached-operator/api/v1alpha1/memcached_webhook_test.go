@@ -0,0 +1,207 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func rawJSON(s string) *runtime.RawExtension {
+	return &runtime.RawExtension{Raw: []byte(s)}
+}
+
+func quantity(s string) *resource.Quantity {
+	q := resource.MustParse(s)
+	return &q
+}
+
+// webhookCases are the specs submitted to the webhooks, both directly and
+// through the API server. New validation rules get a case here.
+var webhookCases = []struct {
+	name string
+	spec MemcachedSpec
+	// err is part of the rejection message, empty when the spec is accepted.
+	err string
+	// size is the size of an accepted spec after defaulting.
+	size int32
+}{
+	{name: "unset size", spec: MemcachedSpec{}, size: 3},
+	{name: "odd size", spec: MemcachedSpec{Size: 5}, size: 5},
+	{name: "even size", spec: MemcachedSpec{Size: 4}, err: "Cluster size must be an odd number"},
+	{name: "memory", spec: MemcachedSpec{Size: 1, Memory: quantity("128Mi")}, size: 1},
+	{name: "zero memory", spec: MemcachedSpec{Size: 1, Memory: quantity("0")}, err: "Memory must be a positive quantity"},
+	{
+		name: "config",
+		spec: MemcachedSpec{Size: 3, ConfigRef: &corev1.LocalObjectReference{Name: "cache-options"}},
+		size: 3,
+	},
+	{
+		name: "unnamed config",
+		spec: MemcachedSpec{Size: 3, ConfigRef: &corev1.LocalObjectReference{}},
+		err:  "ConfigRef must name a ConfigMap",
+	},
+	{
+		name: "pod template",
+		spec: MemcachedSpec{Size: 3, PodTemplate: rawJSON(`{"metadata":{"labels":{"team":"web"}},"spec":{"priorityClassName":"high","containers":[{"name":"memcached","resources":{"limits":{"cpu":"1"}}}]}}`)},
+		size: 3,
+	},
+	{
+		name: "pod template directives",
+		spec: MemcachedSpec{Size: 3, PodTemplate: rawJSON(`{"spec":{"tolerations":[{"$patch":"replace"}],"containers":[{"name":"sidecar","$patch":"delete"}]}}`)},
+		size: 3,
+	},
+	{
+		name: "pod template delete memcached",
+		spec: MemcachedSpec{Size: 3, PodTemplate: rawJSON(`{"spec":{"containers":[{"name":"memcached","$patch":"delete"}]}}`)},
+		err:  "PodTemplate cannot use the $patch directive on the memcached container",
+	},
+	{
+		name: "pod template replace containers",
+		spec: MemcachedSpec{Size: 3, PodTemplate: rawJSON(`{"spec":{"containers":[{"$patch":"replace"},{"name":"sidecar","image":"busybox"}]}}`)},
+		err:  "PodTemplate cannot use the $patch directive on the containers",
+	},
+	{
+		name: "pod template replace labels",
+		spec: MemcachedSpec{Size: 3, PodTemplate: rawJSON(`{"metadata":{"labels":{"$patch":"replace","team":"web"}}}`)},
+		err:  "PodTemplate cannot use the $patch directive on the labels",
+	},
+	{
+		name: "pod template replace spec",
+		spec: MemcachedSpec{Size: 3, PodTemplate: rawJSON(`{"spec":{"$patch":"replace","priorityClassName":"high"}}`)},
+		err:  "PodTemplate cannot use the $patch directive on spec",
+	},
+	{
+		name: "pod template retain keys",
+		spec: MemcachedSpec{Size: 3, PodTemplate: rawJSON(`{"metadata":{"$retainKeys":["annotations"]}}`)},
+		err:  "PodTemplate cannot use the $retainKeys directive on metadata",
+	},
+	{
+		name: "pod template element order",
+		spec: MemcachedSpec{Size: 3, PodTemplate: rawJSON(`{"spec":{"$setElementOrder/containers":[{"name":"sidecar"}]}}`)},
+		err:  "PodTemplate cannot use the $setElementOrder/containers directive on the containers",
+	},
+	{
+		name: "pod template memcached primitive list",
+		spec: MemcachedSpec{Size: 3, PodTemplate: rawJSON(`{"spec":{"containers":[{"name":"memcached","$deleteFromPrimitiveList/command":["-v"]}]}}`)},
+		err:  "PodTemplate cannot use the $deleteFromPrimitiveList/command directive on the memcached container",
+	},
+	{
+		name: "pod template selector label",
+		spec: MemcachedSpec{Size: 3, PodTemplate: rawJSON(`{"metadata":{"labels":{"app":"other"}}}`)},
+		err:  "PodTemplate cannot override the selector label app",
+	},
+	{
+		name: "pod template command",
+		spec: MemcachedSpec{Size: 3, PodTemplate: rawJSON(`{"spec":{"containers":[{"name":"memcached","args":["-t","8"]}]}}`)},
+		err:  "PodTemplate cannot override the command of the memcached container",
+	},
+	{
+		name: "pod template typo",
+		spec: MemcachedSpec{Size: 3, PodTemplate: rawJSON(`{"spec":{"tolerration":[]}}`)},
+		err:  `PodTemplate is not a pod template: json: unknown field "tolerration"`,
+	},
+	{
+		name: "autoscaling",
+		spec: MemcachedSpec{Size: 3, Autoscaling: &AutoscalingSpec{MinReplicas: 1, MaxReplicas: 5}},
+		size: 3,
+	},
+	{
+		name: "even autoscaling bounds",
+		spec: MemcachedSpec{Size: 3, Autoscaling: &AutoscalingSpec{MinReplicas: 2, MaxReplicas: 5}},
+		err:  "Autoscaling minReplicas and maxReplicas must be odd numbers",
+	},
+	{
+		name: "inverted autoscaling bounds",
+		spec: MemcachedSpec{Size: 3, Autoscaling: &AutoscalingSpec{MinReplicas: 5, MaxReplicas: 3}},
+		err:  "Autoscaling minReplicas must not be greater than maxReplicas",
+	},
+}
+
+func TestWebhookCases(t *testing.T) {
+	for _, tc := range webhookCases {
+		m := &Memcached{Spec: *tc.spec.DeepCopy()}
+		m.Default()
+		err := m.ValidateCreate()
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected the spec to be accepted, got %v", tc.name, err)
+		}
+		if m.Spec.Size != tc.size {
+			t.Errorf("%s: expected size %d, got %d", tc.name, tc.size, m.Spec.Size)
+		}
+	}
+}
+
+var _ = Describe("Memcached webhooks", func() {
+	const namespace = "default"
+	ctx := context.Background()
+
+	for i, tc := range webhookCases {
+		i, tc := i, tc
+		It(fmt.Sprintf("admits the %s case on create", tc.name), func() {
+			m := &Memcached{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("create-%d", i), Namespace: namespace},
+				Spec:       *tc.spec.DeepCopy(),
+			}
+			err := k8sClient.Create(ctx, m)
+			if tc.err != "" {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring(tc.err))
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+
+			stored := &Memcached{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: m.Name, Namespace: namespace}, stored)).To(Succeed())
+			Expect(stored.Spec.Size).To(Equal(tc.size))
+		})
+
+		It(fmt.Sprintf("admits the %s case on update", tc.name), func() {
+			m := &Memcached{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("update-%d", i), Namespace: namespace},
+				Spec:       MemcachedSpec{Size: 1},
+			}
+			Expect(k8sClient.Create(ctx, m)).To(Succeed())
+
+			m.Spec = *tc.spec.DeepCopy()
+			err := k8sClient.Update(ctx, m)
+			if tc.err != "" {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring(tc.err))
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m.Spec.Size).To(Equal(tc.size))
+		})
+	}
+})
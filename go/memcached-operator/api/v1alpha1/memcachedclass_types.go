@@ -0,0 +1,100 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultClassAnnotation marks the MemcachedClass applied to the Memcached
+// resources that do not set a className, when set to "true".
+const DefaultClassAnnotation = "cache.example.com/is-default-class"
+
+// MemcachedClassSpec defines the presets of the Memcached resources of a class
+type MemcachedClassSpec struct {
+	// Image is the memcached image, unless the podTemplate sets one.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Memory is the memory of the Memcached resources that do not set one.
+	// +optional
+	Memory *resource.Quantity `json:"memory,omitempty"`
+
+	// Resources are the compute resources of the memcached container.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Scheduling constrains the nodes the memcached pods run on.
+	// +optional
+	Scheduling *SchedulingSpec `json:"scheduling,omitempty"`
+
+	// Monitoring adds a Prometheus exporter to the memcached pods.
+	// +optional
+	Monitoring *MonitoringSpec `json:"monitoring,omitempty"`
+}
+
+// SchedulingSpec defines where the memcached pods of a class are scheduled
+type SchedulingSpec struct {
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+}
+
+// MonitoringSpec defines the exporter of the memcached pods of a class
+type MonitoringSpec struct {
+	// Enabled adds a memcached exporter container to the pods, with the
+	// annotations of Prometheus scraping.
+	Enabled bool `json:"enabled"`
+
+	// ExporterImage is the image of the exporter. Defaults to
+	// prom/memcached-exporter:v0.8.0.
+	// +optional
+	ExporterImage string `json:"exporterImage,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// MemcachedClass is the Schema for the memcachedclasses API. A Memcached
+// names its class with className, and the presets of the class apply to what
+// its spec leaves unset.
+type MemcachedClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MemcachedClassSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MemcachedClassList contains a list of MemcachedClass
+type MemcachedClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MemcachedClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MemcachedClass{}, &MemcachedClassList{})
+}
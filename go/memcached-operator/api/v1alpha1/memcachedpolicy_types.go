@@ -0,0 +1,77 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MemcachedPolicySpec defines the limits on the Memcached resources of every
+// namespace. The limits are checked when Memcached resources are admitted,
+// so resources created at the same time may together exceed them.
+type MemcachedPolicySpec struct {
+	// MaxInstances is the number of Memcached resources a namespace may hold.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxInstances *int32 `json:"maxInstances,omitempty"`
+
+	// MaxReplicas is the number of memcached pods the Memcached resources of
+	// a namespace may run in total. Autoscaled resources count with the
+	// maxReplicas of their autoscaling.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxReplicas *int32 `json:"maxReplicas,omitempty"`
+
+	// MaxMemory is the memory the memcached pods of a namespace may use in
+	// total, counted like MaxReplicas.
+	// +optional
+	MaxMemory *resource.Quantity `json:"maxMemory,omitempty"`
+
+	// AllowedImages lists the images the pods of a Memcached may run, from
+	// its router and podTemplate. An entry ending with * allows the images
+	// starting with the rest of it, such as registry.example.com/*. Any image
+	// is allowed when empty.
+	// +optional
+	AllowedImages []string `json:"allowedImages,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// MemcachedPolicy is the Schema for the memcachedpolicies API. Every policy
+// applies to every namespace, and the admission webhook rejects the creation
+// or update of a Memcached that would break one of them.
+type MemcachedPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MemcachedPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MemcachedPolicyList contains a list of MemcachedPolicy
+type MemcachedPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MemcachedPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MemcachedPolicy{}, &MemcachedPolicyList{})
+}
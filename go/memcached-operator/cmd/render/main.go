@@ -0,0 +1,188 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command render prints the objects the operator creates for the Memcached
+// resources of a YAML file, without a cluster. The ConfigMaps named by their
+// configRef and the MemcachedClasses are read from the same file. The
+// resources are defaulted and validated like by the admission webhooks, so
+// invalid resources are reported as the API server would reject them.
+//
+//	render -f config/samples/cache_v1alpha1_memcached.yaml
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/class"
+	"github.com/example/memcached-operator/pkg/options"
+	"github.com/example/memcached-operator/pkg/resources"
+)
+
+func main() {
+	var file, namespace string
+	flag.StringVar(&file, "f", "-", "The YAML file of the Memcached resources, - for the standard input.")
+	flag.StringVar(&namespace, "namespace", "default", "The namespace of the resources that do not set one.")
+	flag.Parse()
+
+	in := os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "render:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+	if err := run(in, os.Stdout, namespace); err != nil {
+		fmt.Fprintln(os.Stderr, "render:", err)
+		os.Exit(1)
+	}
+}
+
+// run renders the Memcached resources read from in to out, as a stream of
+// YAML documents. The ConfigMaps named by their configRef and their
+// MemcachedClass must be part of the input too.
+func run(in io.Reader, out io.Writer, namespace string) error {
+	var memcacheds []*cachev1alpha1.Memcached
+	var classes []cachev1alpha1.MemcachedClass
+	configMaps := map[types.NamespacedName]*corev1.ConfigMap{}
+	dec := utilyaml.NewYAMLOrJSONDecoder(in, 4096)
+	for {
+		u := &unstructured.Unstructured{}
+		if err := dec.Decode(&u.Object); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if u.Object == nil || u.GetKind() == "" {
+			continue
+		}
+		if u.GetNamespace() == "" && u.GetKind() != "MemcachedClass" {
+			u.SetNamespace(namespace)
+		}
+		switch u.GetKind() {
+		case "Memcached":
+			m := &cachev1alpha1.Memcached{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, m); err != nil {
+				return fmt.Errorf("Memcached %s: %v", u.GetName(), err)
+			}
+			memcacheds = append(memcacheds, m)
+		case "ConfigMap":
+			cm := &corev1.ConfigMap{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, cm); err != nil {
+				return fmt.Errorf("ConfigMap %s: %v", u.GetName(), err)
+			}
+			configMaps[types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}] = cm
+		case "MemcachedClass":
+			c := cachev1alpha1.MemcachedClass{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &c); err != nil {
+				return fmt.Errorf("MemcachedClass %s: %v", u.GetName(), err)
+			}
+			classes = append(classes, c)
+		default:
+			return fmt.Errorf("%s %s is not a Memcached, a MemcachedClass or a ConfigMap", u.GetKind(), u.GetName())
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, m := range memcacheds {
+		m.Default()
+		if err := m.ValidateCreate(); err != nil {
+			return fmt.Errorf("Memcached %s is invalid: %v", m.Name, err)
+		}
+		var args []string
+		if ref := m.Spec.ConfigRef; ref != nil {
+			cm, ok := configMaps[types.NamespacedName{Name: ref.Name, Namespace: m.Namespace}]
+			if !ok {
+				return fmt.Errorf("ConfigMap %s of Memcached %s is not in the input", ref.Name, m.Name)
+			}
+			var err error
+			if args, err = options.Args(cm.Data); err != nil {
+				return fmt.Errorf("ConfigMap %s of Memcached %s is invalid: %v", ref.Name, m.Name, err)
+			}
+		}
+
+		c, err := class.Select(classes, m)
+		if err != nil {
+			return fmt.Errorf("%v in the input, used by Memcached %s", err, m.Name)
+		}
+		effective, err := class.Apply(m, c)
+		if err != nil {
+			return fmt.Errorf("Memcached %s: %v", m.Name, err)
+		}
+
+		objs, err := resources.All(effective, args)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			if buf.Len() > 0 {
+				buf.WriteString("---\n")
+			}
+			if err := writeObject(&buf, obj); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+// writeObject writes obj as YAML, without its status and null fields.
+func writeObject(w io.Writer, obj runtime.Object) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	delete(content, "status")
+	data, err := yaml.Marshal(pruneNulls(content))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func pruneNulls(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, item := range t {
+			if item == nil {
+				delete(t, k)
+			} else {
+				t[k] = pruneNulls(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range t {
+			t[i] = pruneNulls(item)
+		}
+	}
+	return v
+}
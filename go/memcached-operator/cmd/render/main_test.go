@@ -0,0 +1,103 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// TestRenderGolden renders testdata/memcached.yaml and compares the output to
+// render.golden. Run with -update to rewrite the golden file.
+func TestRenderGolden(t *testing.T) {
+	in, err := os.Open(filepath.Join("testdata", "memcached.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	var out bytes.Buffer
+	if err := run(in, &out, "default"); err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "render.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, out.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != string(want) {
+		t.Errorf("output differs from %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+	}
+}
+
+func TestRenderRejectsInvalidMemcached(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		err  string
+	}{
+		{
+			name: "even size",
+			in:   "apiVersion: cache.example.com/v1alpha1\nkind: Memcached\nmetadata:\n  name: even\nspec:\n  size: 4\n",
+			err:  "Memcached even is invalid",
+		},
+		{
+			name: "other kind",
+			in:   "apiVersion: v1\nkind: Secret\nmetadata:\n  name: config\n",
+			err:  "Secret config is not a Memcached, a MemcachedClass or a ConfigMap",
+		},
+		{
+			name: "missing config",
+			in:   "apiVersion: cache.example.com/v1alpha1\nkind: Memcached\nmetadata:\n  name: configured\nspec:\n  configRef:\n    name: options\n",
+			err:  "ConfigMap options of Memcached configured is not in the input",
+		},
+		{
+			name: "invalid config",
+			in: "apiVersion: cache.example.com/v1alpha1\nkind: Memcached\nmetadata:\n  name: configured\nspec:\n  configRef:\n    name: options\n" +
+				"---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: options\ndata:\n  port: \"11212\"\n",
+			err: `ConfigMap options of Memcached configured is invalid: unknown option "port"`,
+		},
+		{
+			name: "missing class",
+			in:   "apiVersion: cache.example.com/v1alpha1\nkind: Memcached\nmetadata:\n  name: classed\nspec:\n  className: large\n",
+			err:  "MemcachedClass large not found in the input, used by Memcached classed",
+		},
+	} {
+		var out bytes.Buffer
+		err := run(strings.NewReader(tc.in), &out, "default")
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.name, tc.err, err)
+		}
+		if out.Len() > 0 {
+			t.Errorf("%s: expected no output, got %q", tc.name, out.String())
+		}
+	}
+}
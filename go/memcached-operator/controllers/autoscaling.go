@@ -0,0 +1,223 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/autoscale"
+	"github.com/example/memcached-operator/pkg/memcache"
+	"github.com/example/memcached-operator/pkg/recommend"
+)
+
+const (
+	// maxScalingHistory is the number of scaling events kept in status.
+	maxScalingHistory = 10
+)
+
+// statsState is what the stats-driven features of a Memcached remember
+// between polls.
+type statsState struct {
+	lastPoll    time.Time
+	stats       map[string]memcache.ServerStats
+	scaler      *autoscale.Scaler
+	recommender *recommend.Recommender
+}
+
+// statsStore keeps the stats state of every Memcached. It only lives in
+// memory: after an operator restart the stabilization windows and the
+// recommendation history start over.
+type statsStore struct {
+	mu     sync.Mutex
+	states map[types.NamespacedName]*statsState
+}
+
+func (s *statsStore) get(key types.NamespacedName) *statsState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = map[types.NamespacedName]*statsState{}
+	}
+	state, ok := s.states[key]
+	if !ok {
+		state = &statsState{scaler: &autoscale.Scaler{}, recommender: &recommend.Recommender{}}
+		s.states[key] = state
+	}
+	return state
+}
+
+func (s *statsStore) delete(key types.NamespacedName) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, key)
+}
+
+// policyFor converts the autoscaling spec of a Memcached to an autoscale.Policy.
+func policyFor(a *cachev1alpha1.AutoscalingSpec) autoscale.Policy {
+	seconds := func(v *int32, def int32) time.Duration {
+		if v == nil {
+			return time.Duration(def) * time.Second
+		}
+		return time.Duration(*v) * time.Second
+	}
+	percent := func(v *int32) float64 {
+		if v == nil {
+			return 0
+		}
+		return float64(*v) / 100
+	}
+	p := autoscale.Policy{
+		MinReplicas:             a.MinReplicas,
+		MaxReplicas:             a.MaxReplicas,
+		TargetHitRatio:          percent(a.TargetHitRatio),
+		TargetMemoryUtilization: percent(a.TargetMemoryUtilization),
+		ScaleUpStabilization:    seconds(a.ScaleUpStabilizationSeconds, 60),
+		ScaleDownStabilization:  seconds(a.ScaleDownStabilizationSeconds, 300),
+		Cooldown:                seconds(a.CooldownSeconds, 120),
+	}
+	if a.TargetConnections != nil {
+		p.TargetConnections = float64(*a.TargetConnections)
+	}
+	return p
+}
+
+// pollStats returns the statistics of the given pods keyed by pod name, or
+// nil when the pods were polled less than a resync interval ago. Pods that
+// cannot be reached, or are left when ctx is done, are left out.
+func (r *MemcachedReconciler) pollStats(ctx context.Context, m *cachev1alpha1.Memcached, pods []corev1.Pod, log logr.Logger) map[string]memcache.ServerStats {
+	state := r.stats.get(types.NamespacedName{Name: m.Name, Namespace: m.Namespace})
+	now := time.Now()
+	if now.Sub(state.lastPoll) < r.resyncInterval() {
+		return nil
+	}
+	state.lastPoll = now
+
+	stats := map[string]memcache.ServerStats{}
+	for _, pod := range pods {
+		if ctx.Err() != nil {
+			break
+		}
+		s, err := memcache.FetchStats(podAddr(pod), r.memcachedTimeout())
+		if err != nil {
+			log.V(1).Info("Failed to poll memcached stats", "pod", pod.Name, "error", err.Error())
+			continue
+		}
+		stats[pod.Name] = s
+	}
+	return stats
+}
+
+// reconcileAutoscaling evaluates the autoscaler from freshly polled
+// statistics and changes the Memcached Size when it decides to. Nothing is
+// evaluated when stats is nil. A change the API server rejects, for instance
+// one denied by a MemcachedPolicy, is reported in a warning event and tried
+// again at the next poll, so the status is still updated.
+func (r *MemcachedReconciler) reconcileAutoscaling(ctx context.Context, m *cachev1alpha1.Memcached, stats map[string]memcache.ServerStats, log logr.Logger) {
+	if m.Spec.Autoscaling == nil {
+		m.Status.Autoscaling = nil
+		return
+	}
+	if stats == nil {
+		return
+	}
+
+	state := r.stats.get(types.NamespacedName{Name: m.Name, Namespace: m.Namespace})
+	status := m.Status.Autoscaling
+	if status == nil {
+		status = &cachev1alpha1.AutoscalingStatus{}
+		m.Status.Autoscaling = status
+	}
+	state.scaler.Policy = policyFor(m.Spec.Autoscaling)
+	if status.LastScaleTime != nil {
+		state.scaler.LastScaleTime = status.LastScaleTime.Time
+	}
+
+	metrics := autoscale.Compute(state.stats, stats)
+	state.stats = stats
+	status.CurrentHitRatio = toPercent(metrics.HitRatio)
+	status.CurrentMemoryUtilization = toPercent(metrics.MemoryUtilization)
+	status.CurrentConnections = nil
+	if metrics.Connections != nil {
+		conns := int32(math.Round(*metrics.Connections))
+		status.CurrentConnections = &conns
+	}
+
+	now := time.Now()
+	decision := state.scaler.Decide(now, m.Spec.Size, metrics)
+	status.DesiredReplicas = decision.Replicas
+	if !decision.Scale {
+		return
+	}
+
+	from := m.Spec.Size
+	scaled := m.DeepCopy()
+	scaled.Spec.Size = decision.Replicas
+	if err := r.update(ctx, scaled); err != nil {
+		log.Error(err, "Failed to scale Memcached", "from", from, "to", decision.Replicas)
+		r.event(m, corev1.EventTypeWarning, "ScaleFailed",
+			fmt.Sprintf("Failed to scale from %d to %d replicas: %v", from, decision.Replicas, err))
+		return
+	}
+	if planFrom(ctx) != nil {
+		return
+	}
+	m.ResourceVersion = scaled.ResourceVersion
+	m.Spec.Size = decision.Replicas
+
+	log.Info("Scaled Memcached", "from", from, "to", decision.Replicas, "reason", decision.Reason)
+	r.event(m, corev1.EventTypeNormal, "Scaled",
+		fmt.Sprintf("Scaled from %d to %d replicas: %s", from, decision.Replicas, decision.Reason))
+	recordScaling(status, now, from, decision.Replicas, decision.Reason)
+}
+
+// recordScaling appends a scaling operation to the autoscaling status. A
+// resize that keeps the Size, such as a change of memory only, starts the
+// cooldown without being added to the history.
+func recordScaling(status *cachev1alpha1.AutoscalingStatus, now time.Time, from, to int32, reason string) {
+	lastScale := metav1.NewTime(now)
+	status.LastScaleTime = &lastScale
+	if from == to {
+		return
+	}
+	status.History = append(status.History, cachev1alpha1.ScalingEvent{
+		Time:   lastScale,
+		From:   from,
+		To:     to,
+		Reason: reason,
+	})
+	if len(status.History) > maxScalingHistory {
+		status.History = status.History[len(status.History)-maxScalingHistory:]
+	}
+}
+
+func toPercent(v *float64) *int32 {
+	if v == nil {
+		return nil
+	}
+	p := int32(math.Round(*v * 100))
+	return &p
+}
@@ -0,0 +1,125 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/chaos"
+	"github.com/example/memcached-operator/pkg/memcache"
+)
+
+func TestRejectedScaleStillUpdatesStatus(t *testing.T) {
+	s := newSimulation(t, &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+		Spec: cachev1alpha1.MemcachedSpec{
+			Size:        3,
+			Autoscaling: &cachev1alpha1.AutoscalingSpec{MinReplicas: 5, MaxReplicas: 7},
+		},
+	})
+	// Every change of the spec is rejected, as by a MemcachedPolicy.
+	c := chaos.New(s.c, 1)
+	c.SetFaults(chaos.Faults{Errors: 1, Verbs: []string{chaos.Update}})
+	recorder := record.NewFakeRecorder(10)
+	s.r.Client, s.r.Recorder = c, recorder
+
+	s.reconcile()
+	m := s.memcached()
+	if m.Spec.Size != 3 {
+		t.Fatalf("expected the rejected scale to leave the size alone, got %d", m.Spec.Size)
+	}
+	if a := m.Status.Autoscaling; a == nil || a.DesiredReplicas != 5 || len(a.History) != 0 {
+		t.Errorf("expected the desired replicas in status without a scaling event, got %+v", a)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "ScaleFailed Failed to scale from 3 to 5 replicas") {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Error("expected a warning event for the rejected scale")
+	}
+
+	s.reconcile()
+	if got := s.memcached().Status.Nodes; len(got) != 3 {
+		t.Errorf("expected the pods in status, got %v", got)
+	}
+}
+
+func TestRecordScaling(t *testing.T) {
+	status := &cachev1alpha1.AutoscalingStatus{}
+	now := time.Now()
+	recordScaling(status, now, 3, 3, "memory")
+	if status.LastScaleTime == nil || len(status.History) != 0 {
+		t.Errorf("expected a memory-only resize to start the cooldown only, got %+v", status)
+	}
+	for i := 0; i < maxScalingHistory+2; i++ {
+		recordScaling(status, now, int32(i), int32(i+1), "load")
+	}
+	if len(status.History) != maxScalingHistory || status.History[0].From != 2 {
+		t.Errorf("expected the latest %d events, got %+v", maxScalingHistory, status.History)
+	}
+}
+
+func TestRecommendationSkipsPartialStats(t *testing.T) {
+	scheme := newTestScheme(t)
+	m := &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+		Spec: cachev1alpha1.MemcachedSpec{
+			Size:        5,
+			Autoscaling: &cachev1alpha1.AutoscalingSpec{MinReplicas: 1, MaxReplicas: 7, Vertical: cachev1alpha1.VerticalModeAuto},
+		},
+	}
+	r := &MemcachedReconciler{Log: logf.Log, Scheme: scheme, Client: fake.NewFakeClientWithScheme(scheme, m.DeepCopy())}
+	state := r.stats.get(types.NamespacedName{Name: m.Name, Namespace: m.Namespace})
+	state.recommender.Config.MinHistory = time.Nanosecond
+
+	// Two of the five pods do not answer.
+	stats := func(pods int) map[string]memcache.ServerStats {
+		all := map[string]memcache.ServerStats{}
+		for i := 0; i < pods; i++ {
+			all[fmt.Sprintf("cache-%d", i)] = memcache.ServerStats{Bytes: 1 << 20, LimitMaxBytes: 64 << 20}
+		}
+		return all
+	}
+	for i := 0; i < 2; i++ {
+		r.reconcileRecommendation(context.TODO(), m, stats(3), logf.Log)
+		time.Sleep(time.Millisecond)
+	}
+	if m.Spec.Size != 5 || m.Status.Recommendation != nil {
+		t.Fatalf("expected partial stats to be ignored, got size %d and %+v", m.Spec.Size, m.Status.Recommendation)
+	}
+
+	for i := 0; i < 2; i++ {
+		r.reconcileRecommendation(context.TODO(), m, stats(5), logf.Log)
+		time.Sleep(time.Millisecond)
+	}
+	if rec := m.Status.Recommendation; rec == nil || rec.Replicas != 5 {
+		t.Errorf("expected a recommendation of 5 replicas, got %+v", rec)
+	}
+}
@@ -0,0 +1,306 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	"github.com/example/memcached-operator/pkg/resources"
+)
+
+// podDeploymentIndex indexes the pods in the cache by the name of the
+// Deployment that controls them through a ReplicaSet.
+const podDeploymentIndex = ".metadata.controller.deployment"
+
+// indexPodByDeployment is the indexer function of podDeploymentIndex. The
+// Deployment is found from the controller reference of the pod, as a
+// ReplicaSet is named after its Deployment and the pod template hash, which
+// the pod is labelled with.
+func indexPodByDeployment(obj runtime.Object) []string {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil
+	}
+	ref := metav1.GetControllerOf(pod)
+	hash := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]
+	if ref == nil || ref.Kind != "ReplicaSet" || hash == "" || !strings.HasSuffix(ref.Name, "-"+hash) {
+		return nil
+	}
+	return []string{strings.TrimSuffix(ref.Name, "-"+hash)}
+}
+
+// NewCache creates the cache of the manager. The Pods, Deployments, Services
+// and ConfigMaps it holds are restricted to those labelled with
+// resources.MemcachedLabel, which the operator sets on the objects it creates
+// and their pod templates. Without the restriction every object of these
+// kinds in the cluster would be kept in memory. Other kinds are cached as
+// usual.
+//
+// controller-runtime cannot select the objects of its cache, so the
+// restricted kinds are held by client-go informers listing and watching with
+// the label selector.
+func NewCache(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+	delegate, err := cache.New(config, opts)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Scheme == nil {
+		opts.Scheme = clientgoscheme.Scheme
+	}
+	resync := 10 * time.Hour
+	if opts.Resync != nil {
+		resync = *opts.Resync
+	}
+	c, err := newScopedCache(delegate, opts.Scheme, newScopedInformerFactory(clientset, opts.Namespace, resync))
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newScopedInformerFactory returns a factory of informers restricted to the
+// objects labelled with resources.MemcachedLabel.
+func newScopedInformerFactory(clientset kubernetes.Interface, namespace string, resync time.Duration) informers.SharedInformerFactory {
+	return informers.NewSharedInformerFactoryWithOptions(clientset, resync,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) { o.LabelSelector = resources.MemcachedLabel }))
+}
+
+// scopedCache serves the kinds of its informers from them, and the other
+// kinds from the delegate cache.
+type scopedCache struct {
+	cache.Cache
+	scheme    *runtime.Scheme
+	start     func(stop <-chan struct{})
+	informers map[schema.GroupVersionKind]toolscache.SharedIndexInformer
+}
+
+func newScopedCache(delegate cache.Cache, scheme *runtime.Scheme, factory informers.SharedInformerFactory) (*scopedCache, error) {
+	c := &scopedCache{Cache: delegate, scheme: scheme, start: factory.Start, informers: map[schema.GroupVersionKind]toolscache.SharedIndexInformer{}}
+	for obj, informer := range map[runtime.Object]toolscache.SharedIndexInformer{
+		&corev1.Pod{}:        factory.Core().V1().Pods().Informer(),
+		&corev1.Service{}:    factory.Core().V1().Services().Informer(),
+		&corev1.ConfigMap{}:  factory.Core().V1().ConfigMaps().Informer(),
+		&appsv1.Deployment{}: factory.Apps().V1().Deployments().Informer(),
+	} {
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			return nil, err
+		}
+		c.informers[gvk] = informer
+	}
+	return c, nil
+}
+
+// informerFor returns the informer of the kind of obj, or nil when the kind
+// is served by the delegate cache. obj may be a list.
+func (c *scopedCache) informerFor(obj runtime.Object) (toolscache.SharedIndexInformer, schema.GroupVersionKind, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return nil, gvk, err
+	}
+	if apimeta.IsListType(obj) {
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+	return c.informers[gvk], gvk, nil
+}
+
+func (c *scopedCache) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	informer, gvk, err := c.informerFor(obj)
+	if err != nil {
+		return err
+	}
+	if informer == nil {
+		return c.Cache.Get(ctx, key, obj)
+	}
+	storeKey := key.Name
+	if key.Namespace != "" {
+		storeKey = key.Namespace + "/" + key.Name
+	}
+	item, exists, err := informer.GetIndexer().GetByKey(storeKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		resource, _ := apimeta.UnsafeGuessKindToResource(gvk)
+		return apierrors.NewNotFound(resource.GroupResource(), key.Name)
+	}
+	found, ok := item.(runtime.Object)
+	if !ok {
+		return fmt.Errorf("cache contained %T, which is not an Object", item)
+	}
+	out := reflect.ValueOf(obj)
+	copied := reflect.ValueOf(found.DeepCopyObject())
+	if out.Type() != copied.Type() {
+		return fmt.Errorf("cannot get a %s into a %T", gvk.Kind, obj)
+	}
+	out.Elem().Set(copied.Elem())
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	return nil
+}
+
+func (c *scopedCache) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	informer, gvk, err := c.informerFor(list)
+	if err != nil {
+		return err
+	}
+	if informer == nil {
+		return c.Cache.List(ctx, list, opts...)
+	}
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+
+	indexer := informer.GetIndexer()
+	var items []interface{}
+	switch {
+	case listOpts.FieldSelector != nil:
+		reqs := listOpts.FieldSelector.Requirements()
+		if len(reqs) != 1 || (reqs[0].Operator != selection.Equals && reqs[0].Operator != selection.DoubleEquals) {
+			return fmt.Errorf("non-exact field matches are not supported by the cache")
+		}
+		items, err = indexer.ByIndex(fieldIndexName(reqs[0].Field), namespacedKey(listOpts.Namespace, reqs[0].Value))
+	case listOpts.Namespace != "":
+		items, err = indexer.ByIndex(toolscache.NamespaceIndex, listOpts.Namespace)
+	default:
+		items = indexer.List()
+	}
+	if err != nil {
+		return err
+	}
+
+	objs := make([]runtime.Object, 0, len(items))
+	for _, item := range items {
+		obj, ok := item.(runtime.Object)
+		if !ok {
+			return fmt.Errorf("cache contained %T, which is not an Object", item)
+		}
+		meta, err := apimeta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		if listOpts.LabelSelector != nil && !listOpts.LabelSelector.Matches(labels.Set(meta.GetLabels())) {
+			continue
+		}
+		obj = obj.DeepCopyObject()
+		obj.GetObjectKind().SetGroupVersionKind(gvk)
+		objs = append(objs, obj)
+	}
+	return apimeta.SetList(list, objs)
+}
+
+func (c *scopedCache) GetInformer(ctx context.Context, obj runtime.Object) (cache.Informer, error) {
+	informer, _, err := c.informerFor(obj)
+	if err != nil {
+		return nil, err
+	}
+	if informer == nil {
+		return c.Cache.GetInformer(ctx, obj)
+	}
+	return informer, nil
+}
+
+func (c *scopedCache) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (cache.Informer, error) {
+	if informer, ok := c.informers[gvk]; ok {
+		return informer, nil
+	}
+	return c.Cache.GetInformerForKind(ctx, gvk)
+}
+
+// Start starts the informers of the restricted kinds, then runs the delegate
+// cache until stop is closed.
+func (c *scopedCache) Start(stop <-chan struct{}) error {
+	c.start(stop)
+	return c.Cache.Start(stop)
+}
+
+func (c *scopedCache) WaitForCacheSync(stop <-chan struct{}) bool {
+	synced := make([]toolscache.InformerSynced, 0, len(c.informers))
+	for _, informer := range c.informers {
+		synced = append(synced, informer.HasSynced)
+	}
+	return toolscache.WaitForCacheSync(stop, synced...) && c.Cache.WaitForCacheSync(stop)
+}
+
+// IndexField indexes the objects of a restricted kind like the cache of
+// controller-runtime does, under the namespace of each object and under all
+// namespaces.
+func (c *scopedCache) IndexField(ctx context.Context, obj runtime.Object, field string, extract client.IndexerFunc) error {
+	informer, _, err := c.informerFor(obj)
+	if err != nil {
+		return err
+	}
+	if informer == nil {
+		return c.Cache.IndexField(ctx, obj, field, extract)
+	}
+	return informer.AddIndexers(toolscache.Indexers{fieldIndexName(field): func(item interface{}) ([]string, error) {
+		obj, ok := item.(runtime.Object)
+		if !ok {
+			return nil, fmt.Errorf("object of type %T is not an Object", item)
+		}
+		meta, err := apimeta.Accessor(obj)
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		for _, value := range extract(obj) {
+			keys = append(keys, namespacedKey("", value))
+			if ns := meta.GetNamespace(); ns != "" {
+				keys = append(keys, namespacedKey(ns, value))
+			}
+		}
+		return keys, nil
+	}})
+}
+
+func fieldIndexName(field string) string {
+	return "field:" + field
+}
+
+// namespacedKey returns the index key of value in namespace, or in all
+// namespaces when namespace is empty.
+func namespacedKey(namespace, value string) string {
+	if namespace == "" {
+		namespace = "__all_namespaces"
+	}
+	return namespace + "/" + value
+}
@@ -0,0 +1,252 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/example/memcached-operator/pkg/resources"
+)
+
+// deploymentPod returns a pod of the named Deployment, controlled by the
+// ReplicaSet of the template with the given hash.
+func deploymentPod(namespace, deployment, hash, name string, labels map[string]string) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      name,
+		Namespace: namespace,
+		Labels:    map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: hash},
+	}}
+	for k, v := range labels {
+		pod.Labels[k] = v
+	}
+	rs := &metav1.ObjectMeta{Name: deployment + "-" + hash, UID: types.UID(deployment + "-" + hash)}
+	pod.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(rs, appsv1.SchemeGroupVersion.WithKind("ReplicaSet"))}
+	return pod
+}
+
+func TestIndexPodByDeployment(t *testing.T) {
+	pod := deploymentPod("default", "cache-mcrouter", "5d8f", "cache-mcrouter-5d8f-x2k", nil)
+	if got := indexPodByDeployment(pod); len(got) != 1 || got[0] != "cache-mcrouter" {
+		t.Errorf("expected the pod to be indexed under its Deployment, got %v", got)
+	}
+	pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey] = "other"
+	if got := indexPodByDeployment(pod); len(got) != 0 {
+		t.Errorf("expected a pod whose ReplicaSet does not match its hash not to be indexed, got %v", got)
+	}
+	pod = deploymentPod("default", "cache", "5d8f", "cache-0", nil)
+	pod.OwnerReferences[0].Kind = "StatefulSet"
+	if got := indexPodByDeployment(pod); len(got) != 0 {
+		t.Errorf("expected a pod not controlled by a ReplicaSet not to be indexed, got %v", got)
+	}
+	pod.OwnerReferences = nil
+	if got := indexPodByDeployment(pod); len(got) != 0 {
+		t.Errorf("expected a pod without controller not to be indexed, got %v", got)
+	}
+}
+
+func TestScopedCache(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		deploymentPod("default", "cache", "abc", "cache-abc-1", resources.MemcachedLabels("cache")),
+		deploymentPod("default", "cache", "def", "cache-def-1", resources.MemcachedLabels("cache")),
+		deploymentPod("default", "cache-mcrouter", "abc", "cache-mcrouter-abc-1", resources.McrouterLabels("cache")),
+		deploymentPod("other", "cache", "abc", "cache-abc-2", resources.MemcachedLabels("cache")),
+		deploymentPod("default", "web", "abc", "web-abc-1", map[string]string{"app": "web"}),
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "default"}},
+	)
+	c, err := newScopedCache(nil, scheme.Scheme, newScopedInformerFactory(clientset, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := c.IndexField(ctx, &corev1.Pod{}, podDeploymentIndex, indexPodByDeployment); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	// Only the informers are started, as the test has no delegate cache.
+	c.start(stop)
+	for gvk, informer := range c.informers {
+		if !cache.WaitForCacheSync(stop, informer.HasSynced) {
+			t.Fatalf("%s informer did not sync", gvk.Kind)
+		}
+	}
+
+	pod := &corev1.Pod{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "cache-abc-1"}, pod); err != nil {
+		t.Fatal(err)
+	}
+	if pod.Name != "cache-abc-1" || pod.Kind != "Pod" {
+		t.Errorf("expected pod cache-abc-1 with its kind, got %s of kind %q", pod.Name, pod.Kind)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web-abc-1"}, pod); !apierrors.IsNotFound(err) {
+		t.Errorf("expected unlabelled pods not to be cached, got %v", err)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "settings"}, &corev1.ConfigMap{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected unlabelled ConfigMaps not to be cached, got %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts []client.ListOption
+		want []string
+	}{
+		{"all", nil, []string{"cache-abc-1", "cache-abc-2", "cache-def-1", "cache-mcrouter-abc-1"}},
+		{"namespace", []client.ListOption{client.InNamespace("default")}, []string{"cache-abc-1", "cache-def-1", "cache-mcrouter-abc-1"}},
+		{"labels", []client.ListOption{client.MatchingLabels(resources.McrouterLabels("cache"))}, []string{"cache-mcrouter-abc-1"}},
+		{"index", []client.ListOption{client.MatchingFields{podDeploymentIndex: "cache"}}, []string{"cache-abc-1", "cache-abc-2", "cache-def-1"}},
+		{"index in namespace", []client.ListOption{client.InNamespace("default"), client.MatchingFields{podDeploymentIndex: "cache"}}, []string{"cache-abc-1", "cache-def-1"}},
+		{"reconciled pods", []client.ListOption{
+			client.InNamespace("default"),
+			client.MatchingLabels(resources.MemcachedLabels("cache")),
+			client.MatchingFields{podDeploymentIndex: "cache"},
+		}, []string{"cache-abc-1", "cache-def-1"}},
+	}
+	for _, tt := range tests {
+		list := &corev1.PodList{}
+		if err := c.List(ctx, list, tt.opts...); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []string
+		for _, pod := range list.Items {
+			got = append(got, pod.Name)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected pods %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+// clusterPods returns the pods of a large cluster, of which only a few
+// belong to Memcacheds.
+func clusterPods() []*corev1.Pod {
+	const (
+		namespaces   = 10
+		pods         = 50000
+		memcacheds   = 50
+		memcachedPod = 3
+	)
+	var all []*corev1.Pod
+	for i := 0; i < pods-memcacheds*memcachedPod; i++ {
+		all = append(all, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("app-%d", i),
+			Namespace: fmt.Sprintf("ns-%d", i%namespaces),
+			Labels:    map[string]string{"app": "web", "pod-template-hash": "abc"},
+		}})
+	}
+	for m := 0; m < memcacheds; m++ {
+		for i := 0; i < memcachedPod; i++ {
+			name := fmt.Sprintf("cache-%d", m)
+			all = append(all, deploymentPod(fmt.Sprintf("ns-%d", m%namespaces), name, "abc",
+				fmt.Sprintf("%s-abc-%d", name, i), resources.MemcachedLabels(name)))
+		}
+	}
+	return all
+}
+
+// newPodIndexer returns an indexer like the one of the manager cache, holding
+// the pods matching selector.
+func newPodIndexer(pods []*corev1.Pod, selector labels.Selector) cache.Indexer {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+		podDeploymentIndex: func(obj interface{}) ([]string, error) {
+			pod := obj.(*corev1.Pod)
+			var keys []string
+			for _, name := range indexPodByDeployment(pod) {
+				keys = append(keys, pod.Namespace+"/"+name)
+			}
+			return keys, nil
+		},
+	})
+	for _, pod := range pods {
+		if selector.Matches(labels.Set(pod.Labels)) {
+			indexer.Add(pod)
+		}
+	}
+	return indexer
+}
+
+// BenchmarkPodCache measures the memory needed to cache the pods of a
+// cluster with 50k pods, with and without the resources.MemcachedLabel selector.
+func BenchmarkPodCache(b *testing.B) {
+	pods := clusterPods()
+	restricted, err := labels.Parse(resources.MemcachedLabel)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bc := range []struct {
+		name     string
+		selector labels.Selector
+	}{
+		{"all", labels.Everything()},
+		{"restricted", restricted},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var cached int
+			for i := 0; i < b.N; i++ {
+				cached = len(newPodIndexer(pods, bc.selector).ListKeys())
+			}
+			b.ReportMetric(float64(cached), "pods")
+		})
+	}
+}
+
+// BenchmarkListMemcachedPods measures listing the pods of a Memcached from a
+// cache of all the pods of the cluster, by label and by podDeploymentIndex.
+func BenchmarkListMemcachedPods(b *testing.B) {
+	indexer := newPodIndexer(clusterPods(), labels.Everything())
+	selector := labels.SelectorFromSet(resources.MemcachedLabels("cache-7"))
+
+	b.Run("label", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			objs, _ := indexer.ByIndex(cache.NamespaceIndex, "ns-7")
+			var found int
+			for _, obj := range objs {
+				if selector.Matches(labels.Set(obj.(*corev1.Pod).Labels)) {
+					found++
+				}
+			}
+			if found != 3 {
+				b.Fatalf("expected 3 pods, found %d", found)
+			}
+		}
+	})
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			objs, _ := indexer.ByIndex(podDeploymentIndex, "ns-7/cache-7")
+			if len(objs) != 3 {
+				b.Fatalf("expected 3 pods, found %d", len(objs))
+			}
+		}
+	})
+}
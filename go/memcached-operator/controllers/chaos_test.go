@@ -0,0 +1,231 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/chaos"
+	"github.com/example/memcached-operator/pkg/resources"
+)
+
+const (
+	// chaosRuns is the number of random histories played.
+	chaosRuns = 20
+	// chaosSteps is the number of steps of each history.
+	chaosSteps = 30
+	// convergenceRounds bounds the reconciles needed to converge once the
+	// faults stop.
+	convergenceRounds = 10
+)
+
+// chaosFaults are the faults injected while a history is played.
+var chaosFaults = chaos.Faults{Errors: 0.1, Conflicts: 0.1, Partial: 0.1, Latency: time.Millisecond}
+
+// TestReconcileConvergesUnderChaos plays random histories of spec edits,
+// tampering with the owned objects, pods becoming ready and reconciles
+// failing on injected API faults, then checks that the reconciler converges
+// once the faults stop. A failing history is replayed with its seed.
+func TestReconcileConvergesUnderChaos(t *testing.T) {
+	for seed := int64(1); seed <= chaosRuns; seed++ {
+		t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+			playChaos(t, seed)
+		})
+	}
+}
+
+func playChaos(t *testing.T, seed int64) {
+	s := newSimulation(t, &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+		Spec:       cachev1alpha1.MemcachedSpec{Size: 3},
+	})
+	faulty := chaos.New(s.c, seed)
+	s.r.Client = faulty
+	rnd := rand.New(rand.NewSource(seed))
+	ctx := context.TODO()
+
+	faulty.SetFaults(chaosFaults)
+	for step := 0; step < chaosSteps; step++ {
+		switch rnd.Intn(6) {
+		case 0:
+			s.update(func(m *cachev1alpha1.Memcached) { editSpec(rnd, m) })
+		case 1:
+			tamperDeployment(t, s, rnd)
+		case 2:
+			deleteOwned(t, s, rnd)
+		case 3:
+			if _, err := s.cluster.SetAllReady(ctx); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			// Failed reconciles are retried by the following steps.
+			s.r.Reconcile(ctrl.Request{NamespacedName: s.key})
+		}
+		if err := s.cluster.Sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	faulty.SetFaults(chaos.Faults{})
+	for round := 0; round < convergenceRounds; round++ {
+		s.reconcile()
+		if _, err := s.cluster.SetAllReady(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.reconcile()
+	checkConverged(t, s)
+}
+
+// editSpec changes the size, memory and router of m at random.
+func editSpec(rnd *rand.Rand, m *cachev1alpha1.Memcached) {
+	m.Spec.Size = []int32{1, 3, 5}[rnd.Intn(3)]
+	m.Spec.Memory = nil
+	if rnd.Intn(2) == 0 {
+		memory := resource.MustParse("128Mi")
+		m.Spec.Memory = &memory
+	}
+	m.Spec.Router = nil
+	if rnd.Intn(3) == 0 {
+		m.Spec.Router = &cachev1alpha1.RouterSpec{Enabled: true}
+	}
+}
+
+// tamperDeployment changes the replicas or command of the memcached
+// Deployment, as a user editing it by hand would.
+func tamperDeployment(t *testing.T, s *simulation, rnd *rand.Rand) {
+	dep := &appsv1.Deployment{}
+	if err := s.c.Get(context.TODO(), s.key, dep); err != nil {
+		if errors.IsNotFound(err) {
+			return
+		}
+		t.Fatal(err)
+	}
+	if rnd.Intn(2) == 0 {
+		replicas := int32(rnd.Intn(8))
+		dep.Spec.Replicas = &replicas
+	} else {
+		dep.Spec.Template.Spec.Containers[0].Command = []string{"memcached", "-m=1", "-o", "modern", "-v"}
+	}
+	if err := s.c.Update(context.TODO(), dep); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deleteOwned deletes the memcached Deployment, with its pods as the garbage
+// collector would, or the endpoints ConfigMap.
+func deleteOwned(t *testing.T, s *simulation, rnd *rand.Rand) {
+	ctx := context.TODO()
+	if rnd.Intn(2) == 0 {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: resources.EndpointsName(s.memcached()), Namespace: s.key.Namespace}}
+		if err := s.c.Delete(ctx, cm); err != nil && !errors.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		return
+	}
+	dep := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: s.key.Name, Namespace: s.key.Namespace}}
+	if err := s.c.Delete(ctx, dep); err != nil && !errors.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	for _, pod := range s.memcachedPods() {
+		pod := pod
+		if err := s.c.Delete(ctx, &pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (s *simulation) memcachedPods() []corev1.Pod {
+	pods := &corev1.PodList{}
+	if err := s.c.List(context.TODO(), pods, client.InNamespace(s.key.Namespace), client.MatchingLabels(resources.MemcachedLabels(s.key.Name))); err != nil {
+		s.t.Fatal(err)
+	}
+	return pods.Items
+}
+
+// checkConverged checks that the objects of the Memcached match its spec,
+// with no duplicates, and that its status and endpoints list its pods.
+func checkConverged(t *testing.T, s *simulation) {
+	ctx := context.TODO()
+	m := s.memcached()
+	want := memcachedDeployment(t, m)
+
+	router := resources.RouterEnabled(m)
+	wantDeployments, wantConfigMaps, wantServices := 1, 1, 0
+	if router {
+		wantDeployments, wantConfigMaps, wantServices = 2, 2, 1
+	}
+	deployments := &appsv1.DeploymentList{}
+	configMaps := &corev1.ConfigMapList{}
+	services := &corev1.ServiceList{}
+	for _, list := range []runtime.Object{deployments, configMaps, services} {
+		if err := s.c.List(ctx, list, client.InNamespace(s.key.Namespace)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(deployments.Items) != wantDeployments || len(configMaps.Items) != wantConfigMaps || len(services.Items) != wantServices {
+		t.Errorf("expected %d Deployments, %d ConfigMaps and %d Services with router %v, got %d, %d and %d",
+			wantDeployments, wantConfigMaps, wantServices, router,
+			len(deployments.Items), len(configMaps.Items), len(services.Items))
+	}
+
+	dep := &appsv1.Deployment{}
+	if err := s.c.Get(ctx, s.key, dep); err != nil {
+		t.Fatal(err)
+	}
+	if *dep.Spec.Replicas != m.Spec.Size || podTemplateOutdated(dep, want) {
+		t.Errorf("expected the Deployment to match the spec, got %d replicas running %v",
+			*dep.Spec.Replicas, dep.Spec.Template.Spec.Containers[0].Command)
+	}
+
+	pods := s.memcachedPods()
+	var names []string
+	for _, pod := range pods {
+		if !reflect.DeepEqual(pod.Spec.Containers[0].Command, want.Spec.Template.Spec.Containers[0].Command) {
+			t.Errorf("expected pod %s to run the current template, got %v", pod.Name, pod.Spec.Containers[0].Command)
+		}
+		names = append(names, pod.Name)
+	}
+	if int32(len(pods)) != m.Spec.Size {
+		t.Errorf("expected %d pods, got %v", m.Spec.Size, names)
+	}
+	sort.Strings(names)
+	nodes := append([]string(nil), m.Status.Nodes...)
+	sort.Strings(nodes)
+	if !reflect.DeepEqual(nodes, names) {
+		t.Errorf("expected the pods %v in status, got %v", names, nodes)
+	}
+	if got := s.servers(); int32(len(got)) != m.Spec.Size {
+		t.Errorf("expected %d published servers, got %v", m.Spec.Size, got)
+	}
+}
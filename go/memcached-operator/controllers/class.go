@@ -0,0 +1,84 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/class"
+)
+
+// applyClass returns m with the presets of its MemcachedClass applied, and
+// records the class in the status of m. A missing class is an error, so the
+// Deployment keeps its previous presets until the class is created.
+func (r *MemcachedReconciler) applyClass(ctx context.Context, m *cachev1alpha1.Memcached) (*cachev1alpha1.Memcached, error) {
+	c, err := class.Resolve(ctx, r, m)
+	if err != nil {
+		return nil, err
+	}
+	effective, err := class.Apply(m, c)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		m.Status.Class = nil
+	} else {
+		m.Status.Class = &cachev1alpha1.ClassStatus{Name: c.Name, Revision: c.Generation}
+	}
+	return effective, nil
+}
+
+// memcachedClassIndex indexes the Memcacheds in the cache by their
+// className, those without one under the empty name.
+const memcachedClassIndex = ".spec.className"
+
+// indexMemcachedByClass is the indexer function of memcachedClassIndex.
+func indexMemcachedByClass(obj runtime.Object) []string {
+	m, ok := obj.(*cachev1alpha1.Memcached)
+	if !ok {
+		return nil
+	}
+	return []string{m.Spec.ClassName}
+}
+
+// memcachedsForClass maps a MemcachedClass to the Memcacheds whose className
+// names it, and to those without a className since the class may be or have
+// been the default one.
+func (r *MemcachedReconciler) memcachedsForClass(o handler.MapObject) []reconcile.Request {
+	var requests []reconcile.Request
+	for _, className := range []string{o.Meta.GetName(), ""} {
+		list := &cachev1alpha1.MemcachedList{}
+		if err := r.List(context.Background(), list, client.MatchingFields{memcachedClassIndex: className}); err != nil {
+			r.Log.Error(err, "Failed to list Memcacheds of MemcachedClass", "memcachedClass", o.Meta.GetName())
+			return nil
+		}
+		for _, m := range list.Items {
+			// Checked again for caches that do not filter on fields.
+			if m.Spec.ClassName == className {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: m.Name, Namespace: m.Namespace}})
+			}
+		}
+	}
+	return requests
+}
@@ -0,0 +1,119 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+)
+
+func classedMemcached(name, className string) *cachev1alpha1.Memcached {
+	return &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       cachev1alpha1.MemcachedSpec{Size: 3, ClassName: className},
+	}
+}
+
+func TestMemcachedsForClass(t *testing.T) {
+	scheme := newTestScheme(t)
+	r := &MemcachedReconciler{Log: logf.Log, Scheme: scheme, Client: fake.NewFakeClientWithScheme(scheme,
+		classedMemcached("a", "large"),
+		classedMemcached("b", "small"),
+		classedMemcached("c", ""),
+	)}
+	c := &cachev1alpha1.MemcachedClass{ObjectMeta: metav1.ObjectMeta{Name: "large"}}
+	got := r.memcachedsForClass(handler.MapObject{Meta: c, Object: c})
+	want := []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Name: "a", Namespace: "default"}},
+		{NamespacedName: types.NamespacedName{Name: "c", Namespace: "default"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if got := indexMemcachedByClass(classedMemcached("a", "large")); !reflect.DeepEqual(got, []string{"large"}) {
+		t.Errorf("expected the Memcached indexed by its class, got %v", got)
+	}
+	if got := indexMemcachedByClass(classedMemcached("c", "")); !reflect.DeepEqual(got, []string{""}) {
+		t.Errorf("expected the Memcached without class indexed under the empty name, got %v", got)
+	}
+}
+
+func TestClassChangeRollsPods(t *testing.T) {
+	s := newSimulation(t, classedMemcached("cache", "large"))
+	ctx := context.TODO()
+
+	// Nothing is applied until the class exists.
+	if _, err := s.r.Reconcile(ctrl.Request{NamespacedName: s.key}); err == nil {
+		t.Fatal("expected the missing MemcachedClass to fail the reconcile")
+	}
+	memory := resource.MustParse("128Mi")
+	c := &cachev1alpha1.MemcachedClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "large", Generation: 1},
+		Spec:       cachev1alpha1.MemcachedClassSpec{Memory: &memory},
+	}
+	if err := s.c.Create(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	s.reconcile()
+	s.setAllReady()
+	s.reconcile()
+	if got := s.images(); got["-m=128"] != 3 {
+		t.Fatalf("expected 3 pods with the memory of the class, got %v", got)
+	}
+	if got := s.memcached().Status.Class; !reflect.DeepEqual(got, &cachev1alpha1.ClassStatus{Name: "large", Revision: 1}) {
+		t.Fatalf("expected the class revision in status, got %+v", got)
+	}
+
+	memory = resource.MustParse("256Mi")
+	c.Spec.Memory, c.Generation = &memory, 2
+	if err := s.c.Update(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	s.reconcile()
+	s.setAllReady()
+	s.reconcile()
+	if got := s.images(); got["-m=256"] != 3 || len(got) != 1 {
+		t.Fatalf("expected the pods to be rolled to the new memory, got %v", got)
+	}
+	if got := s.memcached().Status.Class; got == nil || got.Revision != 2 {
+		t.Errorf("expected revision 2 in status, got %+v", got)
+	}
+
+	// The memory of the spec overrides the class.
+	s.update(func(m *cachev1alpha1.Memcached) {
+		override := resource.MustParse("64Mi")
+		m.Spec.Memory = &override
+	})
+	s.reconcile()
+	s.setAllReady()
+	s.reconcile()
+	if got := s.images(); got["-m=64"] != 3 || len(got) != 1 {
+		t.Errorf("expected the memory of the spec, got %v", got)
+	}
+}
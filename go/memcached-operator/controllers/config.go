@@ -0,0 +1,96 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/options"
+)
+
+// memcachedConfigIndex indexes the Memcacheds in the cache by the name of the
+// ConfigMap of their configRef.
+const memcachedConfigIndex = ".spec.configRef.name"
+
+// indexMemcachedByConfig is the indexer function of memcachedConfigIndex.
+func indexMemcachedByConfig(obj runtime.Object) []string {
+	m, ok := obj.(*cachev1alpha1.Memcached)
+	if !ok || m.Spec.ConfigRef == nil {
+		return nil
+	}
+	return []string{m.Spec.ConfigRef.Name}
+}
+
+// configArgs returns the extra memcached arguments of the ConfigMap named by
+// the configRef of m, or nil when m has none. A missing ConfigMap or an
+// option outside the allow-list is an error, so the Deployment keeps its
+// previous options until the ConfigMap is fixed.
+//
+// The ConfigMap is read from the API server, as the cache only holds the
+// ConfigMaps labelled with resources.MemcachedLabel, and watched so that its
+// changes roll the pods.
+func (r *MemcachedReconciler) configArgs(ctx context.Context, m *cachev1alpha1.Memcached) ([]string, error) {
+	key := types.NamespacedName{Name: m.Name, Namespace: m.Namespace}
+	if m.Spec.ConfigRef == nil {
+		r.configMaps.set(key, "")
+		return nil, nil
+	}
+	r.configMaps.set(key, m.Spec.ConfigRef.Name)
+	cm := &corev1.ConfigMap{}
+	err := r.apiReader().Get(ctx, types.NamespacedName{Name: m.Spec.ConfigRef.Name, Namespace: m.Namespace}, cm)
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("ConfigMap %s of configRef not found", m.Spec.ConfigRef.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	args, err := options.Args(cm.Data)
+	if err != nil {
+		return nil, fmt.Errorf("ConfigMap %s of configRef is invalid: %v", cm.Name, err)
+	}
+	return args, nil
+}
+
+// memcachedsForConfigMap maps a ConfigMap to the Memcacheds of its namespace
+// whose configRef names it.
+func (r *MemcachedReconciler) memcachedsForConfigMap(o handler.MapObject) []reconcile.Request {
+	list := &cachev1alpha1.MemcachedList{}
+	if err := r.List(context.Background(), list,
+		client.InNamespace(o.Meta.GetNamespace()),
+		client.MatchingFields{memcachedConfigIndex: o.Meta.GetName()}); err != nil {
+		r.Log.Error(err, "Failed to list Memcacheds of ConfigMap", "configMap", o.Meta.GetName(), "namespace", o.Meta.GetNamespace())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, m := range list.Items {
+		// Checked again for caches that do not filter on fields.
+		if ref := m.Spec.ConfigRef; ref != nil && ref.Name == o.Meta.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: m.Name, Namespace: m.Namespace}})
+		}
+	}
+	return requests
+}
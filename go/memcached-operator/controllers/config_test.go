@@ -0,0 +1,168 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+)
+
+func configuredMemcached(name, config string) *cachev1alpha1.Memcached {
+	m := &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       cachev1alpha1.MemcachedSpec{Size: 3},
+	}
+	if config != "" {
+		m.Spec.ConfigRef = &corev1.LocalObjectReference{Name: config}
+	}
+	return m
+}
+
+func optionsConfigMap(name string, data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}, Data: data}
+}
+
+func TestConfigArgs(t *testing.T) {
+	scheme := newTestScheme(t)
+	r := &MemcachedReconciler{Log: logf.Log, Scheme: scheme, Client: fake.NewFakeClientWithScheme(scheme,
+		optionsConfigMap("valid", map[string]string{"threads": "8", "disable-cas": "true"}),
+		optionsConfigMap("invalid", map[string]string{"port": "11212"}),
+	)}
+	tests := []struct {
+		config string
+		want   []string
+		err    string
+	}{
+		{config: "", want: nil},
+		{config: "valid", want: []string{"-C", "-t", "8"}},
+		{config: "invalid", err: `ConfigMap invalid of configRef is invalid: unknown option "port"`},
+		{config: "missing", err: "ConfigMap missing of configRef not found"},
+	}
+	for _, tt := range tests {
+		got, err := r.configArgs(context.TODO(), configuredMemcached("cache", tt.config))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: expected an error containing %q, got %v", tt.config, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.config, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.config, tt.want, got)
+		}
+	}
+}
+
+func TestMemcachedsForConfigMap(t *testing.T) {
+	scheme := newTestScheme(t)
+	r := &MemcachedReconciler{Log: logf.Log, Scheme: scheme, Client: fake.NewFakeClientWithScheme(scheme,
+		configuredMemcached("a", "options"),
+		configuredMemcached("b", "other"),
+		configuredMemcached("c", ""),
+	)}
+	cm := optionsConfigMap("options", nil)
+	got := r.memcachedsForConfigMap(handler.MapObject{Meta: cm, Object: cm})
+	want := []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "a", Namespace: "default"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if got := indexMemcachedByConfig(configuredMemcached("a", "options")); !reflect.DeepEqual(got, []string{"options"}) {
+		t.Errorf("expected the Memcached indexed by its ConfigMap, got %v", got)
+	}
+}
+
+func TestConfigChangeRollsPods(t *testing.T) {
+	s := newSimulation(t, configuredMemcached("cache", "options"))
+	ctx := context.TODO()
+
+	// Nothing is applied until the ConfigMap exists.
+	if _, err := s.r.Reconcile(ctrl.Request{NamespacedName: s.key}); err == nil {
+		t.Fatal("expected the missing ConfigMap to fail the reconcile")
+	}
+	cm := optionsConfigMap("options", map[string]string{"threads": "4"})
+	if err := s.c.Create(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+	s.reconcile()
+	s.setAllReady()
+	s.reconcile()
+	if got := s.images(); got["-m=64"] != 3 {
+		t.Fatalf("expected 3 pods, got %v", got)
+	}
+
+	command := func() []string {
+		dep := &appsv1.Deployment{}
+		if err := s.c.Get(ctx, s.key, dep); err != nil {
+			t.Fatal(err)
+		}
+		return dep.Spec.Template.Spec.Containers[0].Command
+	}
+	if got := command(); !reflect.DeepEqual(got[len(got)-2:], []string{"-t", "4"}) {
+		t.Fatalf("expected the options after the spec arguments, got %v", got)
+	}
+	pods := s.memcachedPods()
+
+	// An invalid change keeps the pods on the previous options.
+	cm.Data = map[string]string{"threads": "4", "user": "root"}
+	if err := s.c.Update(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.r.Reconcile(ctrl.Request{NamespacedName: s.key}); err == nil {
+		t.Fatal("expected the invalid ConfigMap to fail the reconcile")
+	}
+	if got := command(); got[len(got)-1] != "4" {
+		t.Fatalf("expected the previous options to be kept, got %v", got)
+	}
+
+	cm.Data = map[string]string{"threads": "8"}
+	if err := s.c.Update(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+	s.reconcile()
+	s.setAllReady()
+	s.reconcile()
+	if got := command(); got[len(got)-1] != "8" {
+		t.Fatalf("expected the new options, got %v", got)
+	}
+	rolled := s.memcachedPods()
+	if len(rolled) != 3 {
+		t.Fatalf("expected 3 pods after the rollout, got %d", len(rolled))
+	}
+	for _, pod := range rolled {
+		for _, old := range pods {
+			if pod.Name == old.Name {
+				t.Errorf("expected pod %s to be replaced", pod.Name)
+			}
+		}
+	}
+}
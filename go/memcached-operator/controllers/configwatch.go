@@ -0,0 +1,148 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// configMapWatches watches the ConfigMaps named by the configRef of the
+// Memcacheds. Those are created by users without resources.MemcachedLabel, so
+// the cache does not hold them. Each ConfigMap is watched on its own,
+// selected by name, for as long as a Memcached refers to it, and its changes
+// are sent to events.
+type configMapWatches struct {
+	clientset kubernetes.Interface
+	events    chan event.GenericEvent
+	stop      <-chan struct{}
+
+	mu sync.Mutex
+	// refs maps each Memcached to the ConfigMap it refers to.
+	refs map[types.NamespacedName]types.NamespacedName
+	// watches holds the channel stopping the informer of each ConfigMap.
+	watches map[types.NamespacedName]chan struct{}
+}
+
+// newConfigMapWatches returns watches whose informers stop with stop.
+func newConfigMapWatches(clientset kubernetes.Interface, stop <-chan struct{}) *configMapWatches {
+	return &configMapWatches{
+		clientset: clientset,
+		events:    make(chan event.GenericEvent),
+		stop:      stop,
+		refs:      map[types.NamespacedName]types.NamespacedName{},
+		watches:   map[types.NamespacedName]chan struct{}{},
+	}
+}
+
+// set records that the Memcached m refers to the ConfigMap named name in its
+// namespace, or to none when name is empty, and starts or stops watching the
+// ConfigMaps accordingly. Nothing is watched on nil watches.
+func (w *configMapWatches) set(m types.NamespacedName, name string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cm := types.NamespacedName{Name: name, Namespace: m.Namespace}
+	old, ok := w.refs[m]
+	if ok && old == cm {
+		return
+	}
+	if ok {
+		delete(w.refs, m)
+		w.release(old)
+	}
+	if name == "" {
+		return
+	}
+	w.refs[m] = cm
+	if _, ok := w.watches[cm]; !ok {
+		w.watches[cm] = w.start(cm)
+	}
+}
+
+// release stops watching cm once no Memcached refers to it.
+func (w *configMapWatches) release(cm types.NamespacedName) {
+	for _, ref := range w.refs {
+		if ref == cm {
+			return
+		}
+	}
+	if done, ok := w.watches[cm]; ok {
+		close(done)
+		delete(w.watches, cm)
+	}
+}
+
+// start runs an informer of the ConfigMap cm until the returned channel is
+// closed or the watches are stopped.
+func (w *configMapWatches) start(cm types.NamespacedName) chan struct{} {
+	selector := fields.OneTermEqualSelector("metadata.name", cm.Name).String()
+	informer := toolscache.NewSharedIndexInformer(&toolscache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.FieldSelector = selector
+			return w.clientset.CoreV1().ConfigMaps(cm.Namespace).List(context.TODO(), opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.FieldSelector = selector
+			return w.clientset.CoreV1().ConfigMaps(cm.Namespace).Watch(context.TODO(), opts)
+		},
+	}, &corev1.ConfigMap{}, 0, toolscache.Indexers{})
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    w.send,
+		UpdateFunc: func(_, obj interface{}) { w.send(obj) },
+		DeleteFunc: w.send,
+	})
+
+	done := make(chan struct{})
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		select {
+		case <-done:
+		case <-w.stop:
+		}
+	}()
+	go informer.Run(stop)
+	return done
+}
+
+// send sends the event of a ConfigMap, unless the watches are stopped.
+func (w *configMapWatches) send(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return
+	}
+	select {
+	case w.events <- event.GenericEvent{Meta: cm, Object: cm}:
+	case <-w.stop:
+	}
+}
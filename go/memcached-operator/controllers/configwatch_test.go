@@ -0,0 +1,83 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapWatches(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "options", Namespace: "default"},
+		Data:       map[string]string{"threads": "4"},
+	}
+	clientset := fake.NewSimpleClientset(cm)
+	stop := make(chan struct{})
+	defer close(stop)
+	w := newConfigMapWatches(clientset, stop)
+
+	// receive returns the name of the next ConfigMap sent, or "" after a
+	// while.
+	receive := func() string {
+		select {
+		case e := <-w.events:
+			return e.Meta.GetName()
+		case <-time.After(5 * time.Second):
+			return ""
+		}
+	}
+
+	a := types.NamespacedName{Name: "a", Namespace: "default"}
+	b := types.NamespacedName{Name: "b", Namespace: "default"}
+	w.set(a, "options")
+	w.set(b, "options")
+	if got := receive(); got != "options" {
+		t.Fatalf("expected the ConfigMap to be sent when watched, got %q", got)
+	}
+	if len(w.watches) != 1 {
+		t.Errorf("expected a single watch of the shared ConfigMap, got %d", len(w.watches))
+	}
+
+	// The ConfigMap is unlabelled, and its changes are still sent.
+	cm.Data["threads"] = "8"
+	if _, err := clientset.CoreV1().ConfigMaps("default").Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(); got != "options" {
+		t.Fatalf("expected the changed ConfigMap to be sent, got %q", got)
+	}
+
+	w.set(a, "")
+	if len(w.watches) != 1 {
+		t.Errorf("expected the ConfigMap to be watched while b refers to it")
+	}
+	w.set(b, "other")
+	if _, ok := w.watches[types.NamespacedName{Name: "options", Namespace: "default"}]; ok || len(w.watches) != 1 {
+		t.Errorf("expected only the other ConfigMap to be watched, got %v", w.watches)
+	}
+
+	// Reconcilers not set up with a manager have no watches.
+	var none *configMapWatches
+	none.set(a, "options")
+}
@@ -0,0 +1,151 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/example/memcached-operator/pkg/warmup"
+)
+
+const (
+	// defaultReconcileTimeout is the ReconcileTimeout when the reconciler does
+	// not set one.
+	defaultReconcileTimeout = time.Minute
+
+	// defaultMemcachedTimeout is the MemcachedTimeout when the reconciler does
+	// not set one.
+	defaultMemcachedTimeout = warmup.DefaultTimeout
+)
+
+// stopOnManagerStop makes the contexts of the reconciles derive from a context
+// cancelled when the manager stops, so that a shutdown interrupts the
+// reconciles in progress instead of waiting for their API calls.
+func (r *MemcachedReconciler) stopOnManagerStop(mgr manager.Manager) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.ctx = ctx
+	return mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		<-stop
+		cancel()
+		return nil
+	}))
+}
+
+// reconcileContext returns the context of a reconcile, bounded by the
+// ReconcileTimeout.
+func (r *MemcachedReconciler) reconcileContext() (context.Context, context.CancelFunc) {
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timeout := r.ReconcileTimeout
+	if timeout <= 0 {
+		timeout = defaultReconcileTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// memcachedTimeout returns the MemcachedTimeout of the reconciler or its default.
+func (r *MemcachedReconciler) memcachedTimeout() time.Duration {
+	if r.MemcachedTimeout > 0 {
+		return r.MemcachedTimeout
+	}
+	return defaultMemcachedTimeout
+}
+
+// WithTimeout returns a client bounding each request it makes with timeout,
+// within the deadline of the context of the request. A zero timeout returns c.
+func WithTimeout(c client.Client, timeout time.Duration) client.Client {
+	if timeout <= 0 {
+		return c
+	}
+	return &timeoutClient{Client: c, timeout: timeout}
+}
+
+type timeoutClient struct {
+	client.Client
+	timeout time.Duration
+}
+
+func (c *timeoutClient) Get(ctx context.Context, key types.NamespacedName, obj runtime.Object) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *timeoutClient) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *timeoutClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *timeoutClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *timeoutClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *timeoutClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *timeoutClient) DeleteAllOf(ctx context.Context, obj runtime.Object, opts ...client.DeleteAllOfOption) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *timeoutClient) Status() client.StatusWriter {
+	return &timeoutStatusWriter{StatusWriter: c.Client.Status(), timeout: c.timeout}
+}
+
+type timeoutStatusWriter struct {
+	client.StatusWriter
+	timeout time.Duration
+}
+
+func (w *timeoutStatusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (w *timeoutStatusWriter) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
@@ -0,0 +1,84 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// deadlineClient records the deadline of the context of its last Get.
+type deadlineClient struct {
+	client.Client
+	deadline time.Time
+	ok       bool
+}
+
+func (c *deadlineClient) Get(ctx context.Context, key types.NamespacedName, obj runtime.Object) error {
+	c.deadline, c.ok = ctx.Deadline()
+	return nil
+}
+
+func TestWithTimeout(t *testing.T) {
+	inner := &deadlineClient{}
+	c := WithTimeout(inner, time.Second)
+
+	start := time.Now()
+	if err := c.Get(context.Background(), types.NamespacedName{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !inner.ok || inner.deadline.After(time.Now().Add(time.Second)) || inner.deadline.Before(start.Add(time.Second)) {
+		t.Errorf("expected the request to be bounded by 1s, got deadline %v", inner.deadline.Sub(start))
+	}
+
+	// A shorter deadline of the caller is kept.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	want, _ := ctx.Deadline()
+	if err := c.Get(ctx, types.NamespacedName{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !inner.deadline.Equal(want) {
+		t.Errorf("expected the deadline of the caller, got %v", inner.deadline.Sub(want))
+	}
+
+	if WithTimeout(inner, 0) != client.Client(inner) {
+		t.Error("expected no wrapping without a timeout")
+	}
+}
+
+func TestReconcileContext(t *testing.T) {
+	base, stop := context.WithCancel(context.Background())
+	r := &MemcachedReconciler{ReconcileTimeout: time.Hour, ctx: base}
+
+	ctx, cancel := r.reconcileContext()
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Hour {
+		t.Errorf("expected the reconcile to be bounded by its timeout, got %v", deadline)
+	}
+	stop()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("expected the reconcile to be cancelled when the manager stops")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/discovery"
+	"github.com/example/memcached-operator/pkg/resources"
+)
+
+// defaultDiscoveryMinInterval is the minimum time between two updates of the
+// endpoints ConfigMap when the reconciler does not set one.
+const defaultDiscoveryMinInterval = 10 * time.Second
+
+// reconcileDiscovery publishes the addresses of the ready pods in the endpoints
+// ConfigMap. Updates are applied in a single request so clients never observe
+// a partial list, and at most once per DiscoveryMinInterval to avoid churn
+// while pods come and go. It returns how long to wait before retrying a
+// postponed update.
+func (r *MemcachedReconciler) reconcileDiscovery(ctx context.Context, m *cachev1alpha1.Memcached, ready []corev1.Pod, log logr.Logger) (time.Duration, error) {
+	var endpoints []discovery.Endpoint
+	for _, pod := range ready {
+		endpoints = append(endpoints, discovery.Endpoint{Pod: pod.Name, Address: podAddr(pod)})
+	}
+
+	found := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: resources.EndpointsName(m), Namespace: m.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		cm, err := resources.EndpointsConfigMap(m, discovery.Order(nil, endpoints), time.Now())
+		if err != nil {
+			return 0, err
+		}
+		log.Info("Publishing endpoints", "configMap", cm.Name, "servers", cm.Data[discovery.ServersKey])
+		if err = r.apply(ctx, cm); err != nil {
+			log.Error(err, "Failed to apply ConfigMap", "configMap", cm.Name)
+			return 0, err
+		}
+		return 0, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get ConfigMap", "configMap", resources.EndpointsName(m))
+		return 0, err
+	}
+
+	cm, err := resources.EndpointsConfigMap(m, discovery.Order(discovery.Decode(found.Data), endpoints), time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if reflect.DeepEqual(found.Data, cm.Data) {
+		return 0, nil
+	}
+	if wait := r.discoveryWait(found); wait > 0 {
+		log.V(1).Info("Postponing endpoints update", "configMap", found.Name, "wait", wait)
+		return wait, nil
+	}
+
+	log.Info("Updating endpoints", "configMap", cm.Name, "servers", cm.Data[discovery.ServersKey])
+	if err = r.apply(ctx, cm); err != nil {
+		log.Error(err, "Failed to apply ConfigMap", "configMap", cm.Name)
+		return 0, err
+	}
+	return 0, nil
+}
+
+// discoveryWait returns how long the next update of cm must be delayed.
+func (r *MemcachedReconciler) discoveryWait(cm *corev1.ConfigMap) time.Duration {
+	interval := r.DiscoveryMinInterval
+	if interval == 0 {
+		interval = defaultDiscoveryMinInterval
+	}
+	last, err := time.Parse(time.RFC3339, cm.Annotations[resources.EndpointsUpdatedAnnotation])
+	if err != nil {
+		return 0
+	}
+	return time.Until(last.Add(interval))
+}
@@ -0,0 +1,101 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/resources"
+)
+
+// reconcileRouter ensures the mcrouter ConfigMap, Deployment and Service match
+// the spec and route to the given ready pods, or removes them when the router
+// is disabled.
+func (r *MemcachedReconciler) reconcileRouter(ctx context.Context, m *cachev1alpha1.Memcached, ready []corev1.Pod, log logr.Logger) error {
+	if !resources.RouterEnabled(m) {
+		m.Status.Router = nil
+		return r.deleteRouter(ctx, m, log)
+	}
+
+	var pool []string
+	for _, pod := range ready {
+		pool = append(pool, podAddr(pod))
+	}
+	sort.Strings(pool)
+
+	cm, err := resources.McrouterConfigMap(m, pool)
+	if err != nil {
+		log.Error(err, "Failed to generate mcrouter config")
+		return err
+	}
+	// mcrouter watches its configuration file and reloads it on changes, so
+	// the pool is updated without restarting the router pods.
+	for _, obj := range []runtime.Object{cm, resources.McrouterDeployment(m), resources.McrouterService(m)} {
+		if err := r.apply(ctx, obj); err != nil {
+			log.Error(err, "Failed to apply mcrouter resource", "kind", fmt.Sprintf("%T", obj), "name", cm.Name)
+			return err
+		}
+	}
+	if !reflect.DeepEqual(m.Status.Router, &cachev1alpha1.RouterStatus{Pool: pool}) {
+		log.Info("Updated mcrouter pool", "pool", pool)
+	}
+
+	m.Status.Router = &cachev1alpha1.RouterStatus{Pool: pool}
+	return nil
+}
+
+// deleteRouter removes the mcrouter resources left over from a disabled router.
+// Objects of the same name that m does not control are left alone.
+func (r *MemcachedReconciler) deleteRouter(ctx context.Context, m *cachev1alpha1.Memcached, log logr.Logger) error {
+	meta := metav1.ObjectMeta{Name: resources.McrouterName(m), Namespace: m.Namespace}
+	for _, obj := range []runtime.Object{
+		&appsv1.Deployment{ObjectMeta: meta},
+		&corev1.Service{ObjectMeta: meta},
+		&corev1.ConfigMap{ObjectMeta: meta},
+	} {
+		// Read through the cache first so disabled routers cost no API calls.
+		if err := r.Get(ctx, types.NamespacedName{Name: meta.Name, Namespace: meta.Namespace}, obj); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			log.Error(err, "Failed to get mcrouter resource", "kind", fmt.Sprintf("%T", obj), "name", meta.Name)
+			return err
+		}
+		if !metav1.IsControlledBy(obj.(metav1.Object), m) {
+			log.V(1).Info("Skipping mcrouter resource not controlled by the Memcached", "kind", fmt.Sprintf("%T", obj), "name", meta.Name)
+			continue
+		}
+		log.Info("Deleting mcrouter resource", "kind", fmt.Sprintf("%T", obj), "name", meta.Name)
+		if err := r.delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete mcrouter resource", "kind", fmt.Sprintf("%T", obj), "name", meta.Name)
+			return err
+		}
+	}
+	return nil
+}
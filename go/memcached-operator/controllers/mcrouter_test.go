@@ -0,0 +1,67 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/resources"
+)
+
+func TestDeleteRouterKeepsObjectsNotControlled(t *testing.T) {
+	m := &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default", UID: "cache-uid"},
+		Spec:       cachev1alpha1.MemcachedSpec{Size: 3},
+	}
+	owned := metav1.ObjectMeta{
+		Name:            resources.McrouterName(m),
+		Namespace:       m.Namespace,
+		OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(m, cachev1alpha1.GroupVersion.WithKind("Memcached"))},
+	}
+	// The user created a Deployment of the same name.
+	users := metav1.ObjectMeta{Name: resources.McrouterName(m), Namespace: m.Namespace, Labels: resources.McrouterLabels(m.Name)}
+	scheme := newTestScheme(t)
+	r := &MemcachedReconciler{Log: logf.Log, Scheme: scheme, Client: fake.NewFakeClientWithScheme(scheme, m,
+		&appsv1.Deployment{ObjectMeta: users},
+		&corev1.Service{ObjectMeta: owned},
+		&corev1.ConfigMap{ObjectMeta: owned},
+	)}
+
+	if err := r.deleteRouter(context.TODO(), m, logf.Log); err != nil {
+		t.Fatal(err)
+	}
+	key := types.NamespacedName{Name: resources.McrouterName(m), Namespace: m.Namespace}
+	if err := r.Get(context.TODO(), key, &appsv1.Deployment{}); err != nil {
+		t.Errorf("expected the Deployment of the user to be kept, got %v", err)
+	}
+	if err := r.Get(context.TODO(), key, &corev1.Service{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the owned Service to be deleted, got %v", err)
+	}
+	if err := r.Get(context.TODO(), key, &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the owned ConfigMap to be deleted, got %v", err)
+	}
+}
@@ -18,36 +18,117 @@ package controllers
 
 import (
 	"context"
-	"reflect"
+	"time"
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/class"
+	"github.com/example/memcached-operator/pkg/plan"
+	"github.com/example/memcached-operator/pkg/resources"
 )
 
 // MemcachedReconciler reconciles a Memcached object
 type MemcachedReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	// APIReader reads objects directly from the API server, bypassing the
+	// cache. It is used to fetch the latest Memcached when a status update
+	// conflicts. Defaults to the Client.
+	APIReader client.Reader
+	Log       logr.Logger
+	Scheme    *runtime.Scheme
+	Recorder  record.EventRecorder
+
+	// DiscoveryMinInterval is the minimum time between two updates of the
+	// endpoints ConfigMap published for clients. Defaults to 10 seconds.
+	DiscoveryMinInterval time.Duration
+
+	// ResyncInterval is how often a Memcached is reconciled when nothing
+	// changes, which is also how often the statistics used by autoscaling and
+	// recommendations are polled. Defaults to 30 seconds.
+	ResyncInterval time.Duration
+
+	// MaxConcurrentReconciles is the number of Memcacheds reconciled in
+	// parallel. Defaults to 1.
+	MaxConcurrentReconciles int
+
+	// Backoff configures how failed reconciles are retried.
+	Backoff Backoff
+
+	// ReconcileTimeout bounds each reconcile, including its API and memcached
+	// requests. Defaults to 1 minute.
+	ReconcileTimeout time.Duration
+
+	// DryRun makes the reconciler report the changes it would make to the
+	// objects of every Memcached in their status and events instead of
+	// making them. A single Memcached is reconciled in dry-run mode with the
+	// cache.example.com/dry-run annotation.
+	DryRun bool
+
+	// MemcachedTimeout bounds each connection and request made to a memcached
+	// pod. Defaults to 2 seconds.
+	MemcachedTimeout time.Duration
+
+	// WarmUpTimeout bounds a warm-up, from the snapshot before a rollout
+	// until every new pod is warmed up. A warm-up taking longer is reported
+	// as failed. Defaults to 10 minutes.
+	WarmUpTimeout time.Duration
+
+	// ctx is cancelled when the manager stops.
+	ctx        context.Context
+	snapshots  snapshotStore
+	stats      statsStore
+	configMaps *configMapWatches
 }
 
+// fieldOwner is the field manager the reconciler applies owned objects with.
+const fieldOwner = client.FieldOwner("memcached-operator")
+
+const (
+	// warmUpPollInterval is how often a Memcached is reconciled while its new
+	// pods are waiting to be warmed up.
+	warmUpPollInterval = 5 * time.Second
+
+	// readinessPollInterval is how often a Memcached is reconciled while some
+	// of its pods are not ready.
+	readinessPollInterval = 10 * time.Second
+
+	// defaultResyncInterval is the ResyncInterval when the reconciler does not
+	// set one.
+	defaultResyncInterval = 30 * time.Second
+
+	// defaultWarmUpTimeout is the WarmUpTimeout when the reconciler does not
+	// set one.
+	defaultWarmUpTimeout = 10 * time.Minute
+)
+
 // +kubebuilder:rbac:groups=cache.example.com,resources=memcacheds,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=cache.example.com,resources=memcacheds/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cache.example.com,resources=memcachedclasses,verbs=get;list;watch
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=configmaps;services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 func (r *MemcachedReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	ctx := context.Background()
-	log := r.Log.WithValues("memcached", req.NamespacedName)
+	ctx, cancel := r.reconcileContext()
+	defer cancel()
+	log := r.Log.WithValues("memcached", req.Name, "namespace", req.Namespace, "reconcileID", uuid.NewUUID())
 	// Fetch the Memcached instance
 	memcached := &cachev1alpha1.Memcached{}
 	err := r.Get(ctx, req.NamespacedName, memcached)
@@ -57,6 +138,9 @@ func (r *MemcachedReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
 			log.Info("Memcached resource not found. Ignoring since object must be deleted")
+			r.snapshots.delete(req.NamespacedName)
+			r.stats.delete(req.NamespacedName)
+			r.configMaps.set(req.NamespacedName, "")
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
@@ -64,120 +148,200 @@ func (r *MemcachedReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, err
 	}
 
-	// Check if the deployment already exists, if not create a new one
-	found := &appsv1.Deployment{}
-	err = r.Get(ctx, types.NamespacedName{Name: memcached.Name, Namespace: memcached.Namespace}, found)
-	if err != nil && errors.IsNotFound(err) {
-		// Define a new deployment
-		dep := r.deploymentForMemcached(memcached)
-		log.Info("Creating a new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
-		err = r.Create(ctx, dep)
-		if err != nil {
-			log.Error(err, "Failed to create new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
-			return ctrl.Result{}, err
+	// List the pods for this memcached's deployment
+	podList := &corev1.PodList{}
+	listOpts := []client.ListOption{
+		client.InNamespace(memcached.Namespace),
+		client.MatchingLabels(resources.MemcachedLabels(memcached.Name)),
+		client.MatchingFields{podDeploymentIndex: memcached.Name},
+	}
+	if err = r.List(ctx, podList, listOpts...); err != nil {
+		log.Error(err, "Failed to list pods")
+		return ctrl.Result{}, err
+	}
+	podNames := resources.PodNames(podList.Items)
+	status := memcached.Status.DeepCopy()
+
+	// In dry-run mode the changes are collected in a plan instead of being
+	// made, and pods are neither snapshotted nor warmed up
+	var changes *plan.Plan
+	if r.dryRun(memcached) {
+		changes = &plan.Plan{}
+		ctx = withPlan(ctx, changes)
+	}
+
+	// Read the extra memcached options of the configRef
+	args, err := r.configArgs(ctx, memcached)
+	if err != nil {
+		log.Error(err, "Failed to read memcached options")
+		r.event(memcached, corev1.EventTypeWarning, "InvalidConfig", err.Error())
+		return ctrl.Result{}, err
+	}
+
+	// Apply the presets of the MemcachedClass to the pods
+	effective, err := r.applyClass(ctx, memcached)
+	if err != nil {
+		log.Error(err, "Failed to apply MemcachedClass")
+		reason := "InvalidClass"
+		if _, ok := err.(*class.NotFoundError); ok {
+			reason = "ClassNotFound"
 		}
-		// Deployment created successfully - return and requeue
-		return ctrl.Result{Requeue: true}, nil
-	} else if err != nil {
-		log.Error(err, "Failed to get Deployment")
+		r.event(memcached, corev1.EventTypeWarning, reason, err.Error())
 		return ctrl.Result{}, err
 	}
 
-	// Ensure the deployment size is the same as the spec
-	size := memcached.Spec.Size
-	if *found.Spec.Replicas != size {
-		found.Spec.Replicas = &size
-		err = r.Update(ctx, found)
-		if err != nil {
-			log.Error(err, "Failed to update Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
-			return ctrl.Result{}, err
+	// Check whether applying the deployment will roll its pods, and if so dump
+	// the cache keys first when the new pods should be warmed up
+	dep, err := resources.MemcachedDeployment(effective, args)
+	if err != nil {
+		log.Error(err, "Failed to build Deployment")
+		r.event(memcached, corev1.EventTypeWarning, "InvalidPodTemplate", err.Error())
+		return ctrl.Result{}, err
+	}
+	found := &appsv1.Deployment{}
+	err = r.Get(ctx, types.NamespacedName{Name: dep.Name, Namespace: dep.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Deployment", "deployment", dep.Name)
+		return ctrl.Result{}, err
+	}
+	if err == nil && podTemplateOutdated(found, dep) {
+		log.Info("Rolling Deployment to the new pod template", "deployment", dep.Name)
+		if warmUpEnabled(memcached) && changes == nil {
+			r.snapshotBeforeRollout(ctx, memcached, podList.Items, log)
 		}
-		// Spec updated - return and requeue
-		return ctrl.Result{Requeue: true}, nil
 	}
 
-	// Update the Memcached status with the pod names
-	// List the pods for this memcached's deployment
-	podList := &corev1.PodList{}
-	listOpts := []client.ListOption{
-		client.InNamespace(memcached.Namespace),
-		client.MatchingLabels(labelsForMemcached(memcached.Name)),
+	// Ensure the deployment exists with the size and pod template of the spec
+	if err = r.apply(ctx, dep); err != nil {
+		log.Error(err, "Failed to apply Deployment", "deployment", dep.Name)
+		return ctrl.Result{}, err
 	}
-	if err = r.List(ctx, podList, listOpts...); err != nil {
-		log.Error(err, "Failed to list pods", "Memcached.Namespace", memcached.Namespace, "Memcached.Name", memcached.Name)
+
+	// Replay the snapshot into the pods created by the rollout
+	var warmUpWait time.Duration
+	if changes == nil && r.warmUpNewPods(ctx, memcached, podList.Items, log) {
+		warmUpWait = warmUpPollInterval
+	}
+
+	// Point the mcrouter front-end at the ready pods
+	ready := readyPods(podList.Items)
+	if err := r.reconcileRouter(ctx, memcached, ready, log); err != nil {
 		return ctrl.Result{}, err
 	}
-	podNames := getPodNames(podList.Items)
 
-	// Update status.Nodes if needed
-	if !reflect.DeepEqual(podNames, memcached.Status.Nodes) {
-		memcached.Status.Nodes = podNames
-		err := r.Status().Update(ctx, memcached)
-		if err != nil {
-			log.Error(err, "Failed to update Memcached status")
-			return ctrl.Result{}, err
-		}
+	// Publish the ready pods to clients
+	discoveryWait, err := r.reconcileDiscovery(ctx, memcached, ready, log)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{}, nil
+	// Resize from the statistics of the pods
+	stats := r.pollStats(ctx, memcached, ready, log)
+	r.reconcileAutoscaling(ctx, memcached, stats, log)
+	r.reconcileRecommendation(ctx, memcached, stats, log)
+
+	// Update status.Nodes and the plan if needed
+	memcached.Status.Nodes = podNames
+	r.reportPlan(memcached, changes)
+	if err := r.updateStatus(ctx, memcached, status, log); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Check again when the pods are expected to become ready
+	var readinessWait time.Duration
+	if int32(len(ready)) < memcached.Spec.Size {
+		log.V(1).Info("Waiting for pods to become ready", "ready", len(ready), "size", memcached.Spec.Size)
+		readinessWait = readinessPollInterval
+	}
+
+	// Come back for whatever is due first, at the latest to resync
+	return ctrl.Result{RequeueAfter: soonest(r.resyncInterval(), warmUpWait, discoveryWait, readinessWait)}, nil
 }
 
-// deploymentForMemcached returns a memcached Deployment object
-func (r *MemcachedReconciler) deploymentForMemcached(m *cachev1alpha1.Memcached) *appsv1.Deployment {
-	ls := labelsForMemcached(m.Name)
-	replicas := m.Spec.Size
-
-	dep := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      m.Name,
-			Namespace: m.Namespace,
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &replicas,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: ls,
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: ls,
-				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{{
-						Image:   "memcached:1.4.36-alpine",
-						Name:    "memcached",
-						Command: []string{"memcached", "-m=64", "-o", "modern", "-v"},
-						Ports: []corev1.ContainerPort{{
-							ContainerPort: 11211,
-							Name:          "memcached",
-						}},
-					}},
-				},
-			},
-		},
-	}
-	// Set Memcached instance as the owner and controller
-	ctrl.SetControllerReference(m, dep, r.Scheme)
-	return dep
+// resyncInterval returns the ResyncInterval of the reconciler or its default.
+func (r *MemcachedReconciler) resyncInterval() time.Duration {
+	if r.ResyncInterval > 0 {
+		return r.ResyncInterval
+	}
+	return defaultResyncInterval
 }
 
-// labelsForMemcached returns the labels for selecting the resources
-// belonging to the given memcached CR name.
-func labelsForMemcached(name string) map[string]string {
-	return map[string]string{"app": "memcached", "memcached_cr": name}
+// soonest returns the shortest of the positive waits, or 0 if there are none.
+func soonest(waits ...time.Duration) time.Duration {
+	var min time.Duration
+	for _, w := range waits {
+		if w > 0 && (min == 0 || w < min) {
+			min = w
+		}
+	}
+	return min
+}
+
+// apply creates or updates an owned object with server-side apply. Only the
+// fields set on obj are owned by the reconciler, so fields set by other
+// writers such as admission webhooks are left alone. obj must have its
+// apiVersion and kind set. In a dry-run reconcile the changes are planned.
+func (r *MemcachedReconciler) apply(ctx context.Context, obj runtime.Object) error {
+	if p := planFrom(ctx); p != nil {
+		return r.planApply(ctx, p, obj)
+	}
+	return r.Patch(ctx, obj, client.Apply, fieldOwner, client.ForceOwnership)
 }
 
-// getPodNames returns the pod names of the array of pods passed in
-func getPodNames(pods []corev1.Pod) []string {
-	var podNames []string
-	for _, pod := range pods {
-		podNames = append(podNames, pod.Name)
+// event records an event on the Memcached if the reconciler has a recorder.
+func (r *MemcachedReconciler) event(m *cachev1alpha1.Memcached, eventType, reason, message string) {
+	if r.Recorder != nil {
+		r.Recorder.Event(m, eventType, reason, message)
 	}
-	return podNames
+}
+
+// podTemplateOutdated returns whether applying the desired Deployment rolls
+// the pods of the found one. The templates are compared by the hash set by
+// resources.MemcachedDeployment, as the API server defaults the template of
+// the found Deployment.
+func podTemplateOutdated(found, desired *appsv1.Deployment) bool {
+	key := resources.TemplateHashAnnotation
+	return found.Spec.Template.Annotations[key] != desired.Spec.Template.Annotations[key]
 }
 
 func (r *MemcachedReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := r.stopOnManagerStop(mgr); err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	r.configMaps = newConfigMapWatches(clientset, r.ctx.Done())
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, podDeploymentIndex, indexPodByDeployment); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &cachev1alpha1.Memcached{}, memcachedConfigIndex, indexMemcachedByConfig); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &cachev1alpha1.Memcached{}, memcachedClassIndex, indexMemcachedByClass); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&cachev1alpha1.Memcached{}).
+		For(&cachev1alpha1.Memcached{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			annotationChangedPredicate{key: cachev1alpha1.DryRunAnnotation}))).
 		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Service{}).
+		Watches(&source.Kind{Type: &corev1.Pod{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(memcachedForPod)},
+			builder.WithPredicates(podChangedPredicate{})).
+		Watches(&source.Channel{Source: r.configMaps.events},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.memcachedsForConfigMap)}).
+		Watches(&source.Kind{Type: &cachev1alpha1.MemcachedClass{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.memcachedsForClass)},
+			builder.WithPredicates(predicate.Or(
+				predicate.GenerationChangedPredicate{},
+				annotationChangedPredicate{key: cachev1alpha1.DefaultClassAnnotation}))).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+			RateLimiter:             r.Backoff.RateLimiter(),
+		}).
 		Complete(r)
 }
@@ -0,0 +1,187 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/resources"
+)
+
+var _ = Describe("Memcached controller", func() {
+	const (
+		namespace = "default"
+		timeout   = 20 * time.Second
+		interval  = 250 * time.Millisecond
+	)
+	ctx := context.Background()
+
+	key := func(name string) types.NamespacedName {
+		return types.NamespacedName{Name: name, Namespace: namespace}
+	}
+
+	// create creates m, retrying while the webhook server of the manager is
+	// starting.
+	create := func(m *cachev1alpha1.Memcached) {
+		Eventually(func() error {
+			return k8sClient.Create(ctx, m)
+		}, timeout, interval).Should(Succeed())
+	}
+
+	// get returns a function fetching the named object into obj, for
+	// Eventually.
+	get := func(name string, obj runtime.Object) func() error {
+		return func() error {
+			return k8sClient.Get(ctx, key(name), obj)
+		}
+	}
+
+	// updateMemcached applies change to the latest version of the named
+	// Memcached, retrying on conflicts with the reconciler.
+	updateMemcached := func(name string, change func(*cachev1alpha1.Memcached)) {
+		Eventually(func() error {
+			m := &cachev1alpha1.Memcached{}
+			if err := k8sClient.Get(ctx, key(name), m); err != nil {
+				return err
+			}
+			change(m)
+			return k8sClient.Update(ctx, m)
+		}, timeout, interval).Should(Succeed())
+	}
+
+	It("creates a Deployment owned by the Memcached with the default size", func() {
+		m := &cachev1alpha1.Memcached{ObjectMeta: metav1.ObjectMeta{Name: "owned", Namespace: namespace}}
+		create(m)
+		Expect(m.Spec.Size).To(Equal(int32(3)))
+
+		dep := &appsv1.Deployment{}
+		Eventually(get("owned", dep), timeout, interval).Should(Succeed())
+		Expect(*dep.Spec.Replicas).To(Equal(int32(3)))
+		Expect(dep.Labels).To(Equal(resources.MemcachedLabels("owned")))
+		Expect(dep.Spec.Template.Spec.Containers).To(HaveLen(1))
+		Expect(dep.Spec.Template.Spec.Containers[0].Image).To(Equal(resources.MemcachedImage))
+
+		owner := metav1.GetControllerOf(dep)
+		Expect(owner).NotTo(BeNil())
+		Expect(owner.Kind).To(Equal("Memcached"))
+		Expect(owner.UID).To(Equal(m.UID))
+		Expect(*owner.BlockOwnerDeletion).To(BeTrue())
+
+		cm := &corev1.ConfigMap{}
+		Eventually(get(resources.EndpointsName(m), cm), timeout, interval).Should(Succeed())
+		Expect(metav1.IsControlledBy(cm, m)).To(BeTrue())
+	})
+
+	It("scales the Deployment with the Memcached", func() {
+		create(&cachev1alpha1.Memcached{
+			ObjectMeta: metav1.ObjectMeta{Name: "scaled", Namespace: namespace},
+			Spec:       cachev1alpha1.MemcachedSpec{Size: 3},
+		})
+		dep := &appsv1.Deployment{}
+		Eventually(get("scaled", dep), timeout, interval).Should(Succeed())
+
+		updateMemcached("scaled", func(m *cachev1alpha1.Memcached) { m.Spec.Size = 5 })
+		Eventually(func() (int32, error) {
+			err := k8sClient.Get(ctx, key("scaled"), dep)
+			if err != nil {
+				return 0, err
+			}
+			return *dep.Spec.Replicas, nil
+		}, timeout, interval).Should(Equal(int32(5)))
+	})
+
+	It("lists the memcached pods in status", func() {
+		create(&cachev1alpha1.Memcached{
+			ObjectMeta: metav1.ObjectMeta{Name: "listed", Namespace: namespace},
+			Spec:       cachev1alpha1.MemcachedSpec{Size: 3},
+		})
+
+		// envtest runs no Deployment controller, so the pods are created
+		// by the test, controlled by a ReplicaSet like in a cluster.
+		createPod := func(memcached, name string) {
+			pod := deploymentPod(namespace, memcached, "5d8f", name, resources.MemcachedLabels(memcached))
+			pod.Spec.Containers = []corev1.Container{{Name: "memcached", Image: resources.MemcachedImage}}
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		}
+		createPod("listed", "listed-a")
+		createPod("listed", "listed-b")
+		// A pod of another Memcached is not listed.
+		createPod("other", "other-a")
+
+		Eventually(func() ([]string, error) {
+			m := &cachev1alpha1.Memcached{}
+			if err := k8sClient.Get(ctx, key("listed"), m); err != nil {
+				return nil, err
+			}
+			return m.Status.Nodes, nil
+		}, timeout, interval).Should(ConsistOf("listed-a", "listed-b"))
+	})
+
+	It("removes the mcrouter objects when the router is disabled", func() {
+		create(&cachev1alpha1.Memcached{
+			ObjectMeta: metav1.ObjectMeta{Name: "routed", Namespace: namespace},
+			Spec: cachev1alpha1.MemcachedSpec{
+				Size:   3,
+				Router: &cachev1alpha1.RouterSpec{Enabled: true},
+			},
+		})
+		name := "routed-mcrouter"
+		for _, obj := range []runtime.Object{&appsv1.Deployment{}, &corev1.Service{}, &corev1.ConfigMap{}} {
+			Eventually(get(name, obj), timeout, interval).Should(Succeed())
+		}
+
+		updateMemcached("routed", func(m *cachev1alpha1.Memcached) { m.Spec.Router.Enabled = false })
+		for _, obj := range []runtime.Object{&appsv1.Deployment{}, &corev1.Service{}, &corev1.ConfigMap{}} {
+			Eventually(func() bool {
+				return errors.IsNotFound(get(name, obj)())
+			}, timeout, interval).Should(BeTrue())
+		}
+	})
+
+	It("leaves the objects of a deleted Memcached to the garbage collector", func() {
+		m := &cachev1alpha1.Memcached{
+			ObjectMeta: metav1.ObjectMeta{Name: "deleted", Namespace: namespace},
+			Spec:       cachev1alpha1.MemcachedSpec{Size: 3},
+		}
+		create(m)
+		dep := &appsv1.Deployment{}
+		Eventually(get("deleted", dep), timeout, interval).Should(Succeed())
+
+		Expect(k8sClient.Delete(ctx, m)).To(Succeed())
+		Eventually(func() bool {
+			return errors.IsNotFound(get("deleted", &cachev1alpha1.Memcached{})())
+		}, timeout, interval).Should(BeTrue())
+
+		// envtest runs no garbage collector: delete the Deployment as it
+		// would, and check the reconcile it triggers does not recreate it.
+		Expect(k8sClient.Delete(ctx, dep)).To(Succeed())
+		Consistently(func() bool {
+			return errors.IsNotFound(get("deleted", &appsv1.Deployment{})())
+		}, 2*time.Second, interval).Should(BeTrue())
+	})
+})
@@ -0,0 +1,138 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/plan"
+)
+
+// planKey is the context key of the plan of a dry-run reconcile.
+type planKey struct{}
+
+// withPlan returns a context in which the changes to objects are added to p
+// instead of being made.
+func withPlan(ctx context.Context, p *plan.Plan) context.Context {
+	return context.WithValue(ctx, planKey{}, p)
+}
+
+// planFrom returns the plan of a dry-run reconcile, or nil.
+func planFrom(ctx context.Context) *plan.Plan {
+	p, _ := ctx.Value(planKey{}).(*plan.Plan)
+	return p
+}
+
+// dryRun returns whether the changes to the objects of m are only planned,
+// because the reconciler runs in dry-run mode or m has the dry-run annotation.
+func (r *MemcachedReconciler) dryRun(m *cachev1alpha1.Memcached) bool {
+	return r.DryRun || m.Annotations[cachev1alpha1.DryRunAnnotation] == "true"
+}
+
+// update updates obj, or plans its update in a dry-run reconcile.
+func (r *MemcachedReconciler) update(ctx context.Context, obj runtime.Object) error {
+	if p := planFrom(ctx); p != nil {
+		return r.planApply(ctx, p, obj)
+	}
+	return r.Update(ctx, obj)
+}
+
+// delete deletes obj, or plans its deletion in a dry-run reconcile.
+func (r *MemcachedReconciler) delete(ctx context.Context, obj runtime.Object) error {
+	if p := planFrom(ctx); p != nil {
+		gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+		if err != nil {
+			return err
+		}
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		p.Add(plan.Removal(gvk.Kind, accessor.GetName()))
+		return nil
+	}
+	return r.Delete(ctx, obj)
+}
+
+// planApply adds to p the changes applying obj would make to the live object.
+func (r *MemcachedReconciler) planApply(ctx context.Context, p *plan.Plan, obj runtime.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+	if err != nil {
+		return err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	desired, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+
+	var live map[string]interface{}
+	found, err := r.Scheme.New(gvk)
+	if err != nil {
+		return err
+	}
+	err = r.Get(ctx, types.NamespacedName{Name: accessor.GetName(), Namespace: accessor.GetNamespace()}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		if live, err = runtime.DefaultUnstructuredConverter.ToUnstructured(found); err != nil {
+			return err
+		}
+	}
+	p.Add(plan.Diff(gvk.Kind, accessor.GetName(), desired, live))
+	return nil
+}
+
+// reportPlan publishes the plan of a dry-run reconcile in the status of m,
+// with an event for each change when the plan changed.
+func (r *MemcachedReconciler) reportPlan(m *cachev1alpha1.Memcached, p *plan.Plan) {
+	var planned []cachev1alpha1.PlannedChange
+	if p != nil {
+		for _, c := range p.Changes {
+			change := cachev1alpha1.PlannedChange{Action: string(c.Action), Kind: c.Kind, Name: c.Name}
+			for _, f := range c.Fields {
+				change.Fields = append(change.Fields, f.String())
+			}
+			planned = append(planned, change)
+		}
+	}
+	if equality.Semantic.DeepEqual(m.Status.Plan, planned) {
+		return
+	}
+	m.Status.Plan = planned
+	for _, c := range planned {
+		message := fmt.Sprintf("Would %s %s %s", c.Action, c.Kind, c.Name)
+		if len(c.Fields) > 0 {
+			message = fmt.Sprintf("%s: %v", message, c.Fields)
+		}
+		r.event(m, corev1.EventTypeNormal, "DryRun", message)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/plan"
+	"github.com/example/memcached-operator/pkg/resources"
+)
+
+// memcachedDeployment returns the memcached Deployment of m, which has no
+// configRef.
+func memcachedDeployment(t *testing.T, m *cachev1alpha1.Memcached) *appsv1.Deployment {
+	dep, err := resources.MemcachedDeployment(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dep
+}
+
+func TestDryRunPlansInsteadOfWriting(t *testing.T) {
+	scheme := newTestScheme(t)
+	m := &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "cache",
+			Namespace:   "default",
+			Annotations: map[string]string{cachev1alpha1.DryRunAnnotation: "true"},
+		},
+		Spec: cachev1alpha1.MemcachedSpec{Size: 3},
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &MemcachedReconciler{Log: logf.Log, Scheme: scheme, Recorder: recorder}
+	live := memcachedDeployment(t, m)
+	router := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: resources.McrouterName(m), Namespace: m.Namespace}}
+	r.Client = fake.NewFakeClientWithScheme(scheme, m, live, router)
+
+	if !r.dryRun(m) {
+		t.Fatal("expected the annotation to enable dry-run mode")
+	}
+	p := &plan.Plan{}
+	ctx := withPlan(context.TODO(), p)
+
+	m.Spec.Size = 5
+	if err := r.apply(ctx, memcachedDeployment(t, m)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.apply(ctx, resources.McrouterService(m)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.delete(ctx, router); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "update Deployment cache\n" +
+		"  spec.replicas: 3 -> 5\n" +
+		"update Service cache-mcrouter\n" +
+		"  metadata.labels: {\"app\":\"mcrouter\",\"memcached_cr\":\"cache\"}\n" +
+		"  metadata.ownerReferences: [{\"apiVersion\":\"cache.example.com/v1alpha1\",\"blockOwnerDeletion\":true,\"contro...\n" +
+		"  spec.ports: [{\"name\":\"mcrouter\",\"port\":5000,\"targetPort\":\"mcrouter\"}]\n" +
+		"  spec.selector: {\"app\":\"mcrouter\",\"memcached_cr\":\"cache\"}\n" +
+		"delete Service cache-mcrouter\n"
+	if got := p.String(); got != want {
+		t.Errorf("unexpected plan\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	found := &appsv1.Deployment{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: "cache", Namespace: "default"}, found); err != nil {
+		t.Fatal(err)
+	}
+	if *found.Spec.Replicas != 3 {
+		t.Errorf("expected the Deployment to be left alone, got %d replicas", *found.Spec.Replicas)
+	}
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: router.Name, Namespace: "default"}, &corev1.Service{}); err != nil {
+		t.Errorf("expected the Service to be left alone, got %v", err)
+	}
+
+	r.reportPlan(m, p)
+	if len(m.Status.Plan) != 3 || m.Status.Plan[0].Fields[0] != "spec.replicas: 3 -> 5" {
+		t.Errorf("expected the plan in status, got %+v", m.Status.Plan)
+	}
+	if len(recorder.Events) != 3 {
+		t.Errorf("expected an event per change, got %d", len(recorder.Events))
+	}
+	r.reportPlan(m, p)
+	if len(recorder.Events) != 3 {
+		t.Errorf("expected no events for an unchanged plan, got %d", len(recorder.Events))
+	}
+	r.reportPlan(m, nil)
+	if m.Status.Plan != nil {
+		t.Errorf("expected the plan to be cleared out of dry-run mode, got %+v", m.Status.Plan)
+	}
+}
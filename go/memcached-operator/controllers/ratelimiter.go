@@ -0,0 +1,90 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
+)
+
+const (
+	// DefaultBackoffBaseDelay is the delay before retrying a failed reconcile
+	// for the first time.
+	DefaultBackoffBaseDelay = 500 * time.Millisecond
+
+	// DefaultBackoffMaxDelay caps the delay between retries of a reconcile
+	// that keeps failing.
+	DefaultBackoffMaxDelay = 5 * time.Minute
+
+	// DefaultBackoffJitter is the default maximum fraction added at random to
+	// each delay.
+	DefaultBackoffJitter = 0.1
+)
+
+// Backoff configures how failed reconciles of a Memcached are retried. The
+// delay doubles with every consecutive failure of the same Memcached, starting
+// from BaseDelay and up to MaxDelay, and a random fraction of up to Jitter is
+// added to it so that Memcacheds failing together are not retried in lockstep.
+type Backoff struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Jitter    float64
+}
+
+// RateLimiter returns the rate limiter of the Memcached controller work
+// queue. Like the controller-runtime default, the overall rate of requeues
+// is also limited, to 10 per second with bursts of 100.
+func (b Backoff) RateLimiter() ratelimiter.RateLimiter {
+	if b.BaseDelay <= 0 {
+		b.BaseDelay = DefaultBackoffBaseDelay
+	}
+	if b.MaxDelay <= 0 {
+		b.MaxDelay = DefaultBackoffMaxDelay
+	}
+	return workqueue.NewMaxOfRateLimiter(
+		&jitterRateLimiter{
+			RateLimiter: workqueue.NewItemExponentialFailureRateLimiter(b.BaseDelay, b.MaxDelay),
+			jitter:      b.Jitter,
+			maxDelay:    b.MaxDelay,
+		},
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
+
+// jitterRateLimiter adds jitter to the delays of another rate limiter, never
+// going past maxDelay.
+type jitterRateLimiter struct {
+	workqueue.RateLimiter
+	jitter   float64
+	maxDelay time.Duration
+}
+
+func (r *jitterRateLimiter) When(item interface{}) time.Duration {
+	delay := r.RateLimiter.When(item)
+	if r.jitter <= 0 {
+		return delay
+	}
+	delay = wait.Jitter(delay, r.jitter)
+	if delay > r.maxDelay {
+		return r.maxDelay
+	}
+	return delay
+}
@@ -0,0 +1,80 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffRateLimiter(t *testing.T) {
+	b := Backoff{BaseDelay: time.Second, MaxDelay: 10 * time.Second, Jitter: 0.5}
+	limiter := b.RateLimiter()
+
+	base := time.Second
+	for i := 0; i < 8; i++ {
+		want := base
+		if want > b.MaxDelay {
+			want = b.MaxDelay
+		}
+		got := limiter.When("cache")
+		if got < want || got > b.MaxDelay || float64(got) > float64(want)*1.5 {
+			t.Errorf("retry %d: expected a delay between %v and %v, got %v", i, want, time.Duration(float64(want)*1.5), got)
+		}
+		base *= 2
+	}
+	if n := limiter.NumRequeues("cache"); n != 8 {
+		t.Errorf("expected 8 requeues, got %d", n)
+	}
+
+	limiter.Forget("cache")
+	if got := limiter.When("cache"); got < time.Second || got > 1500*time.Millisecond {
+		t.Errorf("expected the backoff to start over after Forget, got %v", got)
+	}
+	if got := limiter.When("other"); got < time.Second || got > 1500*time.Millisecond {
+		t.Errorf("expected an independent backoff per Memcached, got %v", got)
+	}
+}
+
+func TestBackoffRateLimiterDefaults(t *testing.T) {
+	limiter := Backoff{}.RateLimiter()
+	if got := limiter.When("cache"); got != DefaultBackoffBaseDelay {
+		t.Errorf("expected %v without jitter, got %v", DefaultBackoffBaseDelay, got)
+	}
+	for i := 0; i < 20; i++ {
+		limiter.When("cache")
+	}
+	if got := limiter.When("cache"); got != DefaultBackoffMaxDelay {
+		t.Errorf("expected the delay to be capped at %v, got %v", DefaultBackoffMaxDelay, got)
+	}
+}
+
+func TestSoonest(t *testing.T) {
+	tests := []struct {
+		waits []time.Duration
+		want  time.Duration
+	}{
+		{[]time.Duration{30 * time.Second}, 30 * time.Second},
+		{[]time.Duration{30 * time.Second, 0, 5 * time.Second}, 5 * time.Second},
+		{[]time.Duration{0, -time.Second}, 0},
+	}
+	for _, tt := range tests {
+		if got := soonest(tt.waits...); got != tt.want {
+			t.Errorf("soonest(%v) = %v, want %v", tt.waits, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/memcache"
+	"github.com/example/memcached-operator/pkg/recommend"
+	"github.com/example/memcached-operator/pkg/resources"
+)
+
+// verticalTolerance is the relative memory difference below which a
+// recommendation is not applied, to avoid rolling the pods for nothing.
+const verticalTolerance = 0.1
+
+// reconcileRecommendation feeds freshly polled statistics to the recommender,
+// publishes its recommendation in status and applies it when the vertical
+// autoscaling mode is Auto. Nothing is evaluated when stats is nil, nor when
+// they were not polled from as many pods as the Size, since a pod that did
+// not answer or a rollout in progress would skew the totals. Like with
+// reconcileAutoscaling, a rejected change is reported in a warning event and
+// the status is still updated.
+func (r *MemcachedReconciler) reconcileRecommendation(ctx context.Context, m *cachev1alpha1.Memcached, stats map[string]memcache.ServerStats, log logr.Logger) {
+	if len(stats) == 0 || int32(len(stats)) != m.Spec.Size {
+		return
+	}
+	state := r.stats.get(types.NamespacedName{Name: m.Name, Namespace: m.Namespace})
+	now := time.Now()
+	sample := recommend.Sample{Time: now, Replicas: m.Spec.Size}
+	for _, s := range stats {
+		sample.Evictions += s.Evictions
+		sample.Bytes += s.Bytes
+		sample.LimitMaxBytes += s.LimitMaxBytes
+	}
+	state.recommender.Observe(sample)
+
+	rec, ok := state.recommender.Recommend()
+	if !ok {
+		return
+	}
+	memory := resource.NewQuantity(rec.Memory, resource.BinarySI)
+	if old := m.Status.Recommendation; old == nil || old.Memory.Cmp(*memory) != 0 || old.Replicas != rec.Replicas {
+		m.Status.Recommendation = &cachev1alpha1.RecommendationStatus{
+			Memory:         *memory,
+			Replicas:       rec.Replicas,
+			Reason:         rec.Reason,
+			LastUpdateTime: metav1.NewTime(now),
+		}
+	}
+
+	a := m.Spec.Autoscaling
+	if a == nil || a.Vertical != cachev1alpha1.VerticalModeAuto {
+		return
+	}
+	if last := m.Status.Autoscaling; last != nil && last.LastScaleTime != nil &&
+		now.Sub(last.LastScaleTime.Time) < policyFor(a).Cooldown {
+		return
+	}
+
+	resized := m.DeepCopy()
+	current := float64(resources.MemoryMegabytes(m) << 20)
+	if math.Abs(float64(rec.Memory)-current)/current > verticalTolerance {
+		resized.Spec.Memory = memory
+	}
+	// Replicas are left to the horizontal autoscaler when it has targets.
+	if a.TargetHitRatio == nil && a.TargetMemoryUtilization == nil && a.TargetConnections == nil {
+		replicas := rec.Replicas
+		if replicas < a.MinReplicas {
+			replicas = a.MinReplicas
+		}
+		if replicas > a.MaxReplicas {
+			replicas = a.MaxReplicas
+		}
+		resized.Spec.Size = replicas
+	}
+	if resources.MemoryMegabytes(resized) == resources.MemoryMegabytes(m) && resized.Spec.Size == m.Spec.Size {
+		return
+	}
+
+	if err := r.update(ctx, resized); err != nil {
+		log.Error(err, "Failed to apply sizing recommendation")
+		r.event(m, corev1.EventTypeWarning, "ResizeFailed",
+			fmt.Sprintf("Failed to resize to %dMi x %d: %v", resources.MemoryMegabytes(resized), resized.Spec.Size, err))
+		return
+	}
+	if planFrom(ctx) != nil {
+		return
+	}
+	reason := fmt.Sprintf("Resized to %dMi x %d: %s", resources.MemoryMegabytes(resized), resized.Spec.Size, rec.Reason)
+	log.Info("Applied sizing recommendation", "memory", resources.MemoryMegabytes(resized), "size", resized.Spec.Size, "reason", rec.Reason)
+	r.event(m, corev1.EventTypeNormal, "Resized", reason)
+	if m.Status.Autoscaling == nil {
+		m.Status.Autoscaling = &cachev1alpha1.AutoscalingStatus{}
+	}
+	recordScaling(m.Status.Autoscaling, now, m.Spec.Size, resized.Spec.Size, reason)
+	m.ResourceVersion = resized.ResourceVersion
+	m.Spec = resized.Spec
+}
@@ -0,0 +1,304 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/clustersim"
+	"github.com/example/memcached-operator/pkg/discovery"
+	"github.com/example/memcached-operator/pkg/memcache"
+	"github.com/example/memcached-operator/pkg/memcache/memcachetest"
+	"github.com/example/memcached-operator/pkg/resources"
+	"github.com/example/memcached-operator/pkg/warmup"
+)
+
+// simulation runs the reconciler against a simulated cluster.
+type simulation struct {
+	t *testing.T
+	r *MemcachedReconciler
+	// c is the client of the cluster, which the reconciler may wrap.
+	c       client.Client
+	cluster *clustersim.Cluster
+	key     types.NamespacedName
+}
+
+func newSimulation(t *testing.T, m *cachev1alpha1.Memcached) *simulation {
+	scheme := newTestScheme(t)
+	c := clustersim.NewFakeClient(scheme, m)
+	cluster := clustersim.New(c)
+	// Addresses of TEST-NET-1, where no memcached answers the stats polls.
+	cluster.PodIP = func(n int) string { return fmt.Sprintf("192.0.2.%d", n) }
+	r := &MemcachedReconciler{
+		Client:               c,
+		Log:                  logf.Log,
+		Scheme:               scheme,
+		DiscoveryMinInterval: time.Nanosecond,
+		MemcachedTimeout:     time.Millisecond,
+	}
+	return &simulation{t: t, r: r, c: c, cluster: cluster, key: types.NamespacedName{Name: m.Name, Namespace: m.Namespace}}
+}
+
+// reconcile reconciles the Memcached then lets the cluster catch up.
+func (s *simulation) reconcile() ctrl.Result {
+	result, err := s.r.Reconcile(ctrl.Request{NamespacedName: s.key})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if err := s.cluster.Sync(context.TODO()); err != nil {
+		s.t.Fatal(err)
+	}
+	return result
+}
+
+func (s *simulation) memcached() *cachev1alpha1.Memcached {
+	m := &cachev1alpha1.Memcached{}
+	if err := s.c.Get(context.TODO(), s.key, m); err != nil {
+		s.t.Fatal(err)
+	}
+	return m
+}
+
+func (s *simulation) update(change func(*cachev1alpha1.Memcached)) {
+	m := s.memcached()
+	change(m)
+	if err := s.c.Update(context.TODO(), m); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func (s *simulation) setAllReady() {
+	if _, err := s.cluster.SetAllReady(context.TODO()); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// servers returns the published addresses, sorted.
+func (s *simulation) servers() []string {
+	cm := &corev1.ConfigMap{}
+	name := types.NamespacedName{Name: resources.EndpointsName(s.memcached()), Namespace: s.key.Namespace}
+	if err := s.c.Get(context.TODO(), name, cm); err != nil {
+		s.t.Fatal(err)
+	}
+	if cm.Data[discovery.ServersKey] == "" {
+		return nil
+	}
+	servers := strings.Split(cm.Data[discovery.ServersKey], ",")
+	sort.Strings(servers)
+	return servers
+}
+
+func (s *simulation) images() map[string]int {
+	pods := &corev1.PodList{}
+	if err := s.c.List(context.TODO(), pods); err != nil {
+		s.t.Fatal(err)
+	}
+	images := map[string]int{}
+	for _, pod := range pods.Items {
+		images[pod.Spec.Containers[0].Command[1]]++
+	}
+	return images
+}
+
+func TestReconcileInSimulatedCluster(t *testing.T) {
+	s := newSimulation(t, &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+		Spec:       cachev1alpha1.MemcachedSpec{Size: 3},
+	})
+
+	// The first reconcile creates the Deployment, whose pods are pending.
+	s.reconcile()
+	result := s.reconcile()
+	if got := s.memcached().Status.Nodes; len(got) != 3 {
+		t.Fatalf("expected the 3 pending pods in status, got %v", got)
+	}
+	if result.RequeueAfter != readinessPollInterval {
+		t.Errorf("expected a requeue to check readiness, got %v", result.RequeueAfter)
+	}
+	if got := s.servers(); len(got) != 0 {
+		t.Errorf("expected no published servers before the pods are ready, got %v", got)
+	}
+
+	s.setAllReady()
+	result = s.reconcile()
+	if got := s.servers(); len(got) != 3 || got[0] != "192.0.2.1:11211" {
+		t.Errorf("expected the 3 ready pods to be published, got %v", got)
+	}
+	if result.RequeueAfter != defaultResyncInterval {
+		t.Errorf("expected a resync once the pods are ready, got %v", result.RequeueAfter)
+	}
+
+	// Scaling up adds pods to status, published once ready.
+	s.update(func(m *cachev1alpha1.Memcached) { m.Spec.Size = 5 })
+	s.reconcile()
+	s.reconcile()
+	if got := s.memcached().Status.Nodes; len(got) != 5 {
+		t.Fatalf("expected 5 pods in status, got %v", got)
+	}
+	if got := s.servers(); len(got) != 3 {
+		t.Errorf("expected only the ready pods to be published, got %v", got)
+	}
+	s.setAllReady()
+	s.reconcile()
+	if got := s.servers(); len(got) != 5 {
+		t.Errorf("expected the 5 ready pods to be published, got %v", got)
+	}
+}
+
+func TestReconcileRollsPodsInSimulatedCluster(t *testing.T) {
+	s := newSimulation(t, &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+		Spec:       cachev1alpha1.MemcachedSpec{Size: 3},
+	})
+	s.reconcile()
+	s.setAllReady()
+	s.reconcile()
+
+	memory := resource.MustParse("128Mi")
+	s.update(func(m *cachev1alpha1.Memcached) { m.Spec.Memory = &memory })
+	s.reconcile()
+	dep := &appsv1.Deployment{}
+	if err := s.c.Get(context.TODO(), s.key, dep); err != nil {
+		t.Fatal(err)
+	}
+	if got := dep.Spec.Template.Spec.Containers[0].Command[1]; got != "-m=128" {
+		t.Fatalf("expected the new memory in the pod template, got %s", got)
+	}
+
+	// The old pods keep serving while the new ones start.
+	if got := s.images(); got["-m=64"] != 3 || got["-m=128"] != 3 {
+		t.Fatalf("expected old and new pods side by side, got %v", got)
+	}
+	s.reconcile()
+	if got := s.servers(); len(got) != 3 {
+		t.Errorf("expected the old pods to stay published, got %v", got)
+	}
+	if got := s.memcached().Status.Nodes; len(got) != 6 {
+		t.Errorf("expected every pod of the rollout in status, got %v", got)
+	}
+
+	s.setAllReady()
+	s.reconcile()
+	s.reconcile()
+	if got := s.images(); got["-m=64"] != 0 || got["-m=128"] != 3 {
+		t.Fatalf("expected the rollout to complete, got %v", got)
+	}
+	if got := s.servers(); len(got) != 3 || got[0] != "192.0.2.4:11211" {
+		t.Errorf("expected the new pods to be published, got %v", got)
+	}
+	if got := s.memcached().Status.Nodes; len(got) != 3 {
+		t.Errorf("expected the new pods in status, got %v", got)
+	}
+}
+
+// TestPodTemplateChangeWarmsNewPods checks that a rollout caused by the
+// podTemplate alone, which leaves the memcached container as it is, still
+// warms the new pods up. Each pod is served by a fake memcached on its own
+// loopback address.
+func TestPodTemplateChangeWarmsNewPods(t *testing.T) {
+	var servers []*memcachetest.Server
+	for n := 1; n <= 6; n++ {
+		server, err := memcachetest.Listen(fmt.Sprintf("127.0.1.%d:%d", n, memcache.DefaultPort))
+		if err != nil {
+			t.Skipf("cannot serve the pods on loopback addresses: %v", err)
+		}
+		defer server.Close()
+		servers = append(servers, server)
+	}
+	s := newSimulation(t, &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+		Spec:       cachev1alpha1.MemcachedSpec{Size: 3, WarmUp: &cachev1alpha1.WarmUpSpec{Enabled: true}},
+	})
+	s.cluster.PodIP = func(n int) string { return fmt.Sprintf("127.0.1.%d", n) }
+	s.r.MemcachedTimeout = time.Second
+	s.reconcile()
+	s.setAllReady()
+	s.reconcile()
+	for i, key := range []string{"a", "b", "c"} {
+		servers[i].Set(memcache.Item{Key: key, Value: []byte(key)})
+	}
+
+	s.update(func(m *cachev1alpha1.Memcached) {
+		m.Spec.PodTemplate = &runtime.RawExtension{Raw: []byte(`{"metadata":{"annotations":{"team":"web"}}}`)}
+	})
+	s.reconcile()
+	if got := s.memcached().Status.WarmUp; got == nil || got.Phase != cachev1alpha1.WarmUpPhaseWarming || got.SnapshotKeys != 3 {
+		t.Fatalf("expected the keys to be snapshotted before the rollout, got %+v", got)
+	}
+	s.setAllReady()
+	s.reconcile()
+	s.reconcile()
+
+	if got := s.memcached().Status.WarmUp; got.Phase != cachev1alpha1.WarmUpPhaseCompleted || len(got.WarmedPods) != 3 {
+		t.Fatalf("expected the new pods to be warmed up, got %+v", got)
+	}
+	for _, server := range servers[3:] {
+		if keys := server.Keys(); len(keys) != 3 {
+			t.Errorf("expected new pod %s to hold the 3 keys, got %v", server.Addr, keys)
+		}
+	}
+}
+
+func TestWarmUpTimesOut(t *testing.T) {
+	r := &MemcachedReconciler{Log: logf.Log, MemcachedTimeout: time.Millisecond, WarmUpTimeout: time.Minute}
+	m := &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+		Spec:       cachev1alpha1.MemcachedSpec{Size: 1, WarmUp: &cachev1alpha1.WarmUpSpec{Enabled: true}},
+		Status:     cachev1alpha1.MemcachedStatus{WarmUp: &cachev1alpha1.WarmUpStatus{Phase: cachev1alpha1.WarmUpPhaseWarming}},
+	}
+	key := types.NamespacedName{Name: m.Name, Namespace: m.Namespace}
+	snap := &warmup.Snapshot{Sources: map[string]bool{"old": true}, Warmed: map[string]bool{}, Taken: time.Now()}
+	r.snapshots.put(key, snap)
+	// A new pod no memcached answers for, so it is never warmed up.
+	pods := []corev1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "new", Namespace: "default"},
+		Status: corev1.PodStatus{
+			PodIP:      "192.0.2.1",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}}
+
+	if !r.warmUpNewPods(context.TODO(), m, pods, logf.Log) || m.Status.WarmUp.Phase != cachev1alpha1.WarmUpPhaseWarming {
+		t.Fatalf("expected the warm-up to go on, got %+v", m.Status.WarmUp)
+	}
+	snap.Taken = time.Now().Add(-2 * time.Minute)
+	if r.warmUpNewPods(context.TODO(), m, pods, logf.Log) {
+		t.Fatal("expected the warm-up to be over")
+	}
+	if got := m.Status.WarmUp; got.Phase != cachev1alpha1.WarmUpPhaseFailed || got.Message != "warm-up did not complete within 1m0s" {
+		t.Errorf("expected the warm-up to time out, got %+v", got)
+	}
+	if r.snapshots.get(key) != nil {
+		t.Error("expected the snapshot to be dropped")
+	}
+}
@@ -0,0 +1,157 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+)
+
+// updateStatus writes the status computed during a reconcile if it
+// semantically differs from old, the status the reconcile started from.
+//
+// The Memcached may have been modified since it was read, for instance by the
+// autoscaler or by a user editing the spec. On a conflict the latest version
+// is fetched from the API server and the status is computed again on top of
+// it with mergeStatus, unless the latest version already holds the same
+// status.
+func (r *MemcachedReconciler) updateStatus(ctx context.Context, m *cachev1alpha1.Memcached, old *cachev1alpha1.MemcachedStatus, log logr.Logger) error {
+	computed := m.Status.DeepCopy()
+	key := types.NamespacedName{Name: m.Name, Namespace: m.Namespace}
+	latest, current := m, old
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		desired := computed
+		if latest != m {
+			desired = mergeStatus(current, old, computed)
+		}
+		if equality.Semantic.DeepEqual(current, desired) {
+			return nil
+		}
+		latest.Status = *desired.DeepCopy()
+		err := r.Status().Update(ctx, latest)
+		if err == nil || !errors.IsConflict(err) {
+			return err
+		}
+		log.V(1).Info("Memcached modified during reconcile, retrying status update")
+		fresh := &cachev1alpha1.Memcached{}
+		if getErr := r.apiReader().Get(ctx, key, fresh); getErr != nil {
+			return getErr
+		}
+		latest, current = fresh, fresh.Status.DeepCopy()
+		return err
+	})
+	if err != nil {
+		log.Error(err, "Failed to update Memcached status")
+		return err
+	}
+	if latest != m {
+		m.ObjectMeta = latest.ObjectMeta
+		m.Spec = latest.Spec
+		m.Status = latest.Status
+	}
+	return nil
+}
+
+// mergeStatus returns the status computed by a reconcile that started from
+// old, recomputed on top of latest, the status of the latest version of the
+// Memcached. What the reconcile observed, such as the nodes, the router pool
+// or the current metrics, is taken as computed. The warm-up progress and the
+// scaling history accumulate across reconciles, so only the changes the
+// reconcile made to them are applied to those of latest.
+func mergeStatus(latest, old, computed *cachev1alpha1.MemcachedStatus) *cachev1alpha1.MemcachedStatus {
+	out := computed.DeepCopy()
+	out.WarmUp = mergeWarmUp(latest.WarmUp, old.WarmUp, computed.WarmUp)
+	out.Autoscaling = mergeAutoscaling(latest.Autoscaling, old.Autoscaling, computed.Autoscaling)
+	return out
+}
+
+// mergeWarmUp applies the warm-up progress made by a reconcile to latest. A
+// warm-up started by the reconcile replaces the one of latest.
+func mergeWarmUp(latest, old, computed *cachev1alpha1.WarmUpStatus) *cachev1alpha1.WarmUpStatus {
+	switch {
+	case equality.Semantic.DeepEqual(old, computed):
+		return latest.DeepCopy()
+	case computed == nil || old == nil || old.Phase != cachev1alpha1.WarmUpPhaseWarming:
+		return computed.DeepCopy()
+	case latest == nil || latest.Phase != cachev1alpha1.WarmUpPhaseWarming || latest.SnapshotKeys != old.SnapshotKeys:
+		return computed.DeepCopy()
+	}
+	out := latest.DeepCopy()
+	out.Phase, out.Message = computed.Phase, computed.Message
+	out.WarmedKeys += computed.WarmedKeys - old.WarmedKeys
+	out.SkippedKeys += computed.SkippedKeys - old.SkippedKeys
+	warmed := map[string]bool{}
+	for _, pod := range out.WarmedPods {
+		warmed[pod] = true
+	}
+	for _, pod := range computed.WarmedPods {
+		if !warmed[pod] {
+			out.WarmedPods = append(out.WarmedPods, pod)
+		}
+	}
+	sort.Strings(out.WarmedPods)
+	return out
+}
+
+// mergeAutoscaling appends the scaling events recorded by a reconcile to the
+// history of latest.
+func mergeAutoscaling(latest, old, computed *cachev1alpha1.AutoscalingStatus) *cachev1alpha1.AutoscalingStatus {
+	if computed == nil {
+		return nil
+	}
+	out := computed.DeepCopy()
+	out.History, out.LastScaleTime = nil, nil
+	if latest != nil {
+		out.History = append(out.History, latest.History...)
+		out.LastScaleTime = latest.LastScaleTime.DeepCopy()
+	}
+	var since *metav1.Time
+	if old != nil {
+		since = old.LastScaleTime
+	}
+	if computed.LastScaleTime.Equal(since) {
+		return out
+	}
+	out.LastScaleTime = computed.LastScaleTime.DeepCopy()
+	for _, event := range computed.History {
+		if since == nil || since.Before(&event.Time) {
+			out.History = append(out.History, event)
+		}
+	}
+	if len(out.History) > maxScalingHistory {
+		out.History = out.History[len(out.History)-maxScalingHistory:]
+	}
+	return out
+}
+
+// apiReader returns the reader used to fetch the latest version of objects.
+func (r *MemcachedReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
@@ -0,0 +1,235 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+)
+
+// statusClient counts status updates and lets a test modify the Memcached
+// right before the first of them, as a concurrent writer would.
+type statusClient struct {
+	client.Client
+	updates   int
+	beforeOne func()
+	err       error
+}
+
+func (c *statusClient) Status() client.StatusWriter {
+	return &statusWriter{StatusWriter: c.Client.Status(), c: c}
+}
+
+type statusWriter struct {
+	client.StatusWriter
+	c *statusClient
+}
+
+func (w *statusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	w.c.updates++
+	if w.c.beforeOne != nil {
+		w.c.beforeOne()
+		w.c.beforeOne = nil
+	}
+	if w.c.err != nil {
+		return w.c.err
+	}
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+// newTestScheme returns a scheme with the built-in and Memcached types.
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := cachev1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func newStatusTest(t *testing.T) (*MemcachedReconciler, *statusClient, *cachev1alpha1.Memcached) {
+	scheme := newTestScheme(t)
+	stored := &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+		Spec:       cachev1alpha1.MemcachedSpec{Size: 3},
+		Status:     cachev1alpha1.MemcachedStatus{Nodes: []string{"a"}},
+	}
+	c := &statusClient{Client: fake.NewFakeClientWithScheme(scheme, stored)}
+	r := &MemcachedReconciler{Client: c, Log: logf.Log, Scheme: scheme}
+
+	m := &cachev1alpha1.Memcached{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "cache", Namespace: "default"}, m); err != nil {
+		t.Fatal(err)
+	}
+	return r, c, m
+}
+
+func (c *statusClient) stored(t *testing.T) *cachev1alpha1.Memcached {
+	m := &cachev1alpha1.Memcached{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "cache", Namespace: "default"}, m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestUpdateStatusSkipsNoopWrites(t *testing.T) {
+	r, c, m := newStatusTest(t)
+	old := m.Status.DeepCopy()
+	old.Nodes = nil
+	// An empty list of nodes is semantically the same as no list.
+	m.Status.Nodes = []string{}
+
+	if err := r.updateStatus(context.TODO(), m, old, logf.Log); err != nil {
+		t.Fatal(err)
+	}
+	if c.updates != 0 {
+		t.Errorf("expected no status write, got %d", c.updates)
+	}
+}
+
+func TestUpdateStatusRetriesOnConcurrentModification(t *testing.T) {
+	r, c, m := newStatusTest(t)
+	old := m.Status.DeepCopy()
+	m.Status.Nodes = []string{"a", "b"}
+
+	// Someone scales the Memcached while the reconcile is running.
+	c.beforeOne = func() {
+		concurrent := c.stored(t)
+		concurrent.Spec.Size = 5
+		if err := c.Client.Update(context.TODO(), concurrent); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.updateStatus(context.TODO(), m, old, logf.Log); err != nil {
+		t.Fatal(err)
+	}
+	if c.updates != 2 {
+		t.Errorf("expected a conflict then a successful write, got %d writes", c.updates)
+	}
+	got := c.stored(t)
+	if got.Spec.Size != 5 {
+		t.Errorf("expected the concurrent spec change to be kept, got size %d", got.Spec.Size)
+	}
+	if !reflect.DeepEqual(got.Status.Nodes, []string{"a", "b"}) {
+		t.Errorf("expected the new status to be written, got %v", got.Status.Nodes)
+	}
+	if m.Spec.Size != 5 || m.ResourceVersion != got.ResourceVersion {
+		t.Errorf("expected the reconciled object to be refreshed, got size %d at %s", m.Spec.Size, m.ResourceVersion)
+	}
+}
+
+func TestUpdateStatusRecomputesOnTheLatestStatus(t *testing.T) {
+	r, c, m := newStatusTest(t)
+	start := metav1.Unix(1000, 0)
+	m.Status.WarmUp = &cachev1alpha1.WarmUpStatus{Phase: cachev1alpha1.WarmUpPhaseWarming, SnapshotKeys: 10}
+	m.Status.Autoscaling = &cachev1alpha1.AutoscalingStatus{
+		LastScaleTime: &start,
+		History:       []cachev1alpha1.ScalingEvent{{Time: start, From: 1, To: 3}},
+	}
+	if err := c.Client.Status().Update(context.TODO(), m); err != nil {
+		t.Fatal(err)
+	}
+	old := m.Status.DeepCopy()
+
+	// This reconcile warms a pod up and scales from 3 to 5.
+	m.Status.Nodes = []string{"a", "b"}
+	m.Status.WarmUp.WarmedKeys, m.Status.WarmUp.WarmedPods = 10, []string{"b"}
+	recordScaling(m.Status.Autoscaling, start.Add(2*time.Second), 3, 5, "load")
+
+	// A concurrent reconcile warmed another pod up and scaled from 3 to 7.
+	c.beforeOne = func() {
+		concurrent := c.stored(t)
+		concurrent.Status.WarmUp.WarmedKeys, concurrent.Status.WarmUp.WarmedPods = 8, []string{"c"}
+		recordScaling(concurrent.Status.Autoscaling, start.Add(time.Second), 3, 7, "load")
+		if err := c.Client.Status().Update(context.TODO(), concurrent); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.updateStatus(context.TODO(), m, old, logf.Log); err != nil {
+		t.Fatal(err)
+	}
+	got := c.stored(t).Status
+	if !reflect.DeepEqual(got.Nodes, []string{"a", "b"}) {
+		t.Errorf("expected the observed nodes, got %v", got.Nodes)
+	}
+	if w := got.WarmUp; w.WarmedKeys != 18 || !reflect.DeepEqual(w.WarmedPods, []string{"b", "c"}) {
+		t.Errorf("expected the progress of both reconciles, got %+v", w)
+	}
+	var tos []int32
+	for _, event := range got.Autoscaling.History {
+		tos = append(tos, event.To)
+	}
+	if !reflect.DeepEqual(tos, []int32{3, 7, 5}) {
+		t.Errorf("expected the scaling events of both reconciles, got %v", tos)
+	}
+	if !reflect.DeepEqual(m.Status, got) {
+		t.Errorf("expected the reconciled object to hold the written status, got %+v", m.Status)
+	}
+}
+
+func TestUpdateStatusStopsWhenLatestAlreadyMatches(t *testing.T) {
+	r, c, m := newStatusTest(t)
+	old := m.Status.DeepCopy()
+	m.Status.Nodes = []string{"a", "b"}
+
+	// Another reconcile already wrote the same status.
+	c.beforeOne = func() {
+		concurrent := c.stored(t)
+		concurrent.Status.Nodes = []string{"a", "b"}
+		if err := c.Client.Status().Update(context.TODO(), concurrent); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.updateStatus(context.TODO(), m, old, logf.Log); err != nil {
+		t.Fatal(err)
+	}
+	if c.updates != 1 {
+		t.Errorf("expected only the conflicting write, got %d writes", c.updates)
+	}
+}
+
+func TestUpdateStatusReturnsOtherErrors(t *testing.T) {
+	r, c, m := newStatusTest(t)
+	old := m.Status.DeepCopy()
+	m.Status.Nodes = nil
+	c.err = errors.New("boom")
+
+	if err := r.updateStatus(context.TODO(), m, old, logf.Log); err == nil {
+		t.Fatal("expected the error to be returned")
+	}
+	if c.updates != 1 {
+		t.Errorf("expected no retry on errors other than conflicts, got %d writes", c.updates)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	. "github.com/onsi/gomega"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
@@ -31,6 +32,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/policy"
+	"github.com/example/memcached-operator/pkg/testenv"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -40,8 +43,16 @@ import (
 var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
+var stopManager chan struct{}
 
+// TestAPIs runs the integration specs against a control plane started by
+// envtest, with the manager running the reconciler and the webhooks. The
+// control plane binaries are found by testenv.Setup; the specs are skipped
+// when they are not available.
 func TestAPIs(t *testing.T) {
+	if testenv.Setup("..") == "" {
+		t.Skipf("envtest binaries not found, set %s or run make test", testenv.AssetsEnv)
+	}
 	RegisterFailHandler(Fail)
 
 	RunSpecsWithDefaultAndCustomReporters(t,
@@ -54,7 +65,11 @@ var _ = BeforeSuite(func(done Done) {
 
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
-		CRDDirectoryPaths: []string{filepath.Join("..", "config", "crd", "bases")},
+		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
+		ErrorIfCRDPathMissing: true,
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			DirectoryPaths: []string{filepath.Join("..", "config", "webhook")},
+		},
 	}
 
 	var err error
@@ -71,10 +86,46 @@ var _ = BeforeSuite(func(done Done) {
 	Expect(err).ToNot(HaveOccurred())
 	Expect(k8sClient).ToNot(BeNil())
 
+	By("starting the manager")
+	webhooks := testEnv.WebhookInstallOptions
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             scheme.Scheme,
+		MetricsBindAddress: "0",
+		Host:               webhooks.LocalServingHost,
+		Port:               webhooks.LocalServingPort,
+		CertDir:            webhooks.LocalServingCertDir,
+		NewCache:           NewCache,
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&MemcachedReconciler{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Log:       ctrl.Log.WithName("controllers").WithName("Memcached"),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("memcached-controller"),
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+	err = (&cachev1alpha1.Memcached{}).SetupWebhookWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+	err = policy.SetupWebhookWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	stopManager = make(chan struct{})
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(stopManager)).To(Succeed())
+	}()
+
 	close(done)
 }, 60)
 
 var _ = AfterSuite(func() {
+	By("stopping the manager")
+	if stopManager != nil {
+		close(stopManager)
+	}
+
 	By("tearing down the test environment")
 	err := testEnv.Stop()
 	Expect(err).ToNot(HaveOccurred())
@@ -0,0 +1,196 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/memcache"
+	"github.com/example/memcached-operator/pkg/warmup"
+)
+
+// snapshotStore keeps the warm-up snapshots of the Memcached instances that are
+// being rolled. Snapshots only live in memory: a warm-up interrupted by an
+// operator restart is reported as failed.
+type snapshotStore struct {
+	mu        sync.Mutex
+	snapshots map[types.NamespacedName]*warmup.Snapshot
+}
+
+func (s *snapshotStore) get(key types.NamespacedName) *warmup.Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshots[key]
+}
+
+func (s *snapshotStore) put(key types.NamespacedName, snap *warmup.Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.snapshots == nil {
+		s.snapshots = map[types.NamespacedName]*warmup.Snapshot{}
+	}
+	s.snapshots[key] = snap
+}
+
+func (s *snapshotStore) delete(key types.NamespacedName) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.snapshots, key)
+}
+
+// warmUpEnabled returns whether the Memcached asks for cache warm-up.
+func warmUpEnabled(m *cachev1alpha1.Memcached) bool {
+	return m.Spec.WarmUp != nil && m.Spec.WarmUp.Enabled
+}
+
+// warmerFor returns a Warmer configured from the Memcached spec.
+func (r *MemcachedReconciler) warmerFor(m *cachev1alpha1.Memcached) *warmup.Warmer {
+	w := &warmup.Warmer{Timeout: r.memcachedTimeout()}
+	if m.Spec.WarmUp != nil {
+		w.MaxKeys = int(m.Spec.WarmUp.MaxKeys)
+	}
+	return w
+}
+
+// snapshotBeforeRollout dumps the keys of the ready pods so they can be
+// replayed once the replacement pods are ready.
+func (r *MemcachedReconciler) snapshotBeforeRollout(ctx context.Context, m *cachev1alpha1.Memcached, pods []corev1.Pod, log logr.Logger) {
+	key := types.NamespacedName{Name: m.Name, Namespace: m.Namespace}
+	snap, err := r.warmerFor(m).Take(podAddrs(readyPods(pods)))
+	if err != nil {
+		log.Error(err, "Failed to snapshot keys before rollout")
+		r.snapshots.delete(key)
+		m.Status.WarmUp = &cachev1alpha1.WarmUpStatus{
+			Phase:   cachev1alpha1.WarmUpPhaseFailed,
+			Message: err.Error(),
+		}
+		return
+	}
+	log.Info("Snapshotted keys before rollout", "keys", len(snap.Keys), "pods", len(snap.Sources))
+	r.snapshots.put(key, snap)
+	m.Status.WarmUp = &cachev1alpha1.WarmUpStatus{
+		Phase:        cachev1alpha1.WarmUpPhaseWarming,
+		SnapshotKeys: int32(len(snap.Keys)),
+	}
+}
+
+// warmUpTimeout returns the WarmUpTimeout of the reconciler or its default.
+func (r *MemcachedReconciler) warmUpTimeout() time.Duration {
+	if r.WarmUpTimeout > 0 {
+		return r.WarmUpTimeout
+	}
+	return defaultWarmUpTimeout
+}
+
+// warmUpNewPods replays the snapshot into every ready pod that was neither a
+// source of the snapshot nor warmed yet, and records the progress in the
+// Memcached status. It returns true while the warm-up is still in progress.
+// The pods left when ctx is done are warmed up by a later reconcile, unless
+// the warm-up has outlived the WarmUpTimeout, which fails it.
+func (r *MemcachedReconciler) warmUpNewPods(ctx context.Context, m *cachev1alpha1.Memcached, pods []corev1.Pod, log logr.Logger) bool {
+	status := m.Status.WarmUp
+	if status == nil || status.Phase != cachev1alpha1.WarmUpPhaseWarming {
+		return false
+	}
+	key := types.NamespacedName{Name: m.Name, Namespace: m.Namespace}
+	snap := r.snapshots.get(key)
+	if snap == nil {
+		status.Phase = cachev1alpha1.WarmUpPhaseFailed
+		status.Message = "snapshot lost, the operator restarted during the warm-up"
+		return false
+	}
+	if timeout := r.warmUpTimeout(); time.Since(snap.Taken) > timeout {
+		log.Info("Warm-up timed out", "timeout", timeout, "warmedPods", len(snap.Warmed))
+		status.Phase = cachev1alpha1.WarmUpPhaseFailed
+		status.Message = fmt.Sprintf("warm-up did not complete within %s", timeout)
+		r.snapshots.delete(key)
+		return false
+	}
+
+	ready := readyPods(pods)
+	w := r.warmerFor(m)
+	for _, pod := range ready {
+		if ctx.Err() != nil {
+			break
+		}
+		if snap.Sources[pod.Name] || snap.Warmed[pod.Name] {
+			continue
+		}
+		var peers []string
+		for _, peer := range ready {
+			if peer.Name != pod.Name && (snap.Sources[peer.Name] || snap.Warmed[peer.Name]) {
+				peers = append(peers, podAddr(peer))
+			}
+		}
+		progress, err := w.Replay(ctx, snap, podAddr(pod), peers)
+		if err != nil {
+			log.Error(err, "Failed to warm up pod", "pod", pod.Name)
+			continue
+		}
+		log.Info("Warmed up pod", "pod", pod.Name, "warmed", progress.Warmed, "skipped", progress.Skipped)
+		snap.Warmed[pod.Name] = true
+		status.WarmedKeys += progress.Warmed
+		status.SkippedKeys += progress.Skipped
+		status.WarmedPods = append(status.WarmedPods, pod.Name)
+	}
+	sort.Strings(status.WarmedPods)
+
+	// The rollout is over once no source pod is left and every pod has been warmed.
+	for _, pod := range pods {
+		if snap.Sources[pod.Name] || !snap.Warmed[pod.Name] {
+			return true
+		}
+	}
+	status.Phase = cachev1alpha1.WarmUpPhaseCompleted
+	r.snapshots.delete(key)
+	return false
+}
+
+// readyPods returns the pods that are ready to serve and not being deleted.
+func readyPods(pods []corev1.Pod) []corev1.Pod {
+	var ready []corev1.Pod
+	for _, pod := range pods {
+		if pod.DeletionTimestamp == nil && pod.Status.PodIP != "" && podReady(pod) {
+			ready = append(ready, pod)
+		}
+	}
+	return ready
+}
+
+// podAddrs returns the memcached address of each pod keyed by pod name.
+func podAddrs(pods []corev1.Pod) map[string]string {
+	addrs := map[string]string{}
+	for _, pod := range pods {
+		addrs[pod.Name] = podAddr(pod)
+	}
+	return addrs
+}
+
+func podAddr(pod corev1.Pod) string {
+	return net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(memcache.DefaultPort))
+}
@@ -0,0 +1,98 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/example/memcached-operator/pkg/resources"
+)
+
+// memcachedForPod maps a memcached pod to the Memcached it belongs to, which
+// is found from the labels set by resources.MemcachedLabels. Other pods are
+// ignored.
+func memcachedForPod(o handler.MapObject) []reconcile.Request {
+	name, ok := memcachedOfPod(o.Meta)
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: o.Meta.GetNamespace()}}}
+}
+
+// memcachedOfPod returns the name of the Memcached a memcached pod belongs to.
+func memcachedOfPod(pod metav1.Object) (string, bool) {
+	podLabels := pod.GetLabels()
+	name, ok := podLabels[resources.MemcachedLabel]
+	if !ok || !labels.SelectorFromSet(resources.MemcachedLabels(name)).Matches(labels.Set(podLabels)) {
+		return "", false
+	}
+	return name, true
+}
+
+// annotationChangedPredicate passes updates that change the value of an
+// annotation.
+type annotationChangedPredicate struct {
+	predicate.Funcs
+	key string
+}
+
+func (p annotationChangedPredicate) Update(e event.UpdateEvent) bool {
+	if e.MetaOld == nil || e.MetaNew == nil {
+		return false
+	}
+	return e.MetaOld.GetAnnotations()[p.key] != e.MetaNew.GetAnnotations()[p.key]
+}
+
+// podChangedPredicate passes pod updates that change what the reconciler
+// reads from pods: their phase, readiness and address, and whether they are
+// being deleted. Status updates such as container restarts counts or probe
+// timestamps are filtered out. Creations and deletions always pass.
+type podChangedPredicate struct {
+	predicate.Funcs
+}
+
+func (podChangedPredicate) Update(e event.UpdateEvent) bool {
+	old, ok := e.ObjectOld.(*corev1.Pod)
+	if !ok {
+		return false
+	}
+	pod, ok := e.ObjectNew.(*corev1.Pod)
+	if !ok {
+		return false
+	}
+	return old.Status.Phase != pod.Status.Phase ||
+		podReady(*old) != podReady(*pod) ||
+		old.Status.PodIP != pod.Status.PodIP ||
+		(old.DeletionTimestamp == nil) != (pod.DeletionTimestamp == nil)
+}
+
+// podReady returns whether the Ready condition of a pod is true.
+func podReady(pod corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
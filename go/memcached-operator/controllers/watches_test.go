@@ -0,0 +1,108 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/example/memcached-operator/pkg/resources"
+)
+
+func TestMemcachedForPod(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   []reconcile.Request
+	}{
+		{
+			name:   "memcached pod",
+			labels: map[string]string{"app": "memcached", "memcached_cr": "cache", "pod-template-hash": "abc"},
+			want:   []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "cache", Namespace: "default"}}},
+		},
+		{
+			name:   "mcrouter pod",
+			labels: resources.McrouterLabels("cache"),
+		},
+		{
+			name:   "unrelated pod",
+			labels: map[string]string{"app": "memcached"},
+		},
+	}
+	for _, tt := range tests {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: tt.labels}}
+		got := memcachedForPod(handler.MapObject{Meta: pod, Object: pod})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestPodChangedPredicate(t *testing.T) {
+	pod := func(mutate func(*corev1.Pod)) *corev1.Pod {
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", ResourceVersion: "1"},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				PodIP: "10.0.0.1",
+				Conditions: []corev1.PodCondition{
+					{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+				},
+			},
+		}
+		mutate(p)
+		return p
+	}
+	now := metav1.Now()
+	tests := []struct {
+		name   string
+		mutate func(*corev1.Pod)
+		want   bool
+	}{
+		{"resync", func(p *corev1.Pod) {}, false},
+		{"restart count", func(p *corev1.Pod) {
+			p.ResourceVersion = "2"
+			p.Status.ContainerStatuses = []corev1.ContainerStatus{{RestartCount: 1}}
+		}, false},
+		{"phase", func(p *corev1.Pod) { p.Status.Phase = corev1.PodFailed }, true},
+		{"readiness", func(p *corev1.Pod) { p.Status.Conditions[0].Status = corev1.ConditionFalse }, true},
+		{"address", func(p *corev1.Pod) { p.Status.PodIP = "10.0.0.2" }, true},
+		{"deletion", func(p *corev1.Pod) { p.DeletionTimestamp = &now }, true},
+	}
+	for _, tt := range tests {
+		old, cur := pod(func(*corev1.Pod) {}), pod(tt.mutate)
+		e := event.UpdateEvent{MetaOld: old, ObjectOld: old, MetaNew: cur, ObjectNew: cur}
+		if got := (podChangedPredicate{}).Update(e); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	created := pod(func(*corev1.Pod) {})
+	if !(podChangedPredicate{}).Create(event.CreateEvent{Meta: created, Object: created}) {
+		t.Error("expected pod creations to pass")
+	}
+	if !(podChangedPredicate{}).Delete(event.DeleteEvent{Meta: created, Object: created}) {
+		t.Error("expected pod deletions to pass")
+	}
+}
@@ -18,17 +18,20 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
 	"github.com/example/memcached-operator/controllers"
+	"github.com/example/memcached-operator/pkg/logging"
+	"github.com/example/memcached-operator/pkg/policy"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -47,13 +50,48 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var endpointsMinInterval time.Duration
+	var resyncInterval time.Duration
+	var maxConcurrentReconciles int
+	var backoff controllers.Backoff
+	var reconcileTimeout, apiTimeout, memcachedTimeout, warmUpTimeout time.Duration
+	var dryRun bool
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&endpointsMinInterval, "endpoints-min-interval", 10*time.Second,
+		"The minimum time between two updates of the endpoints ConfigMap published for clients.")
+	flag.DurationVar(&resyncInterval, "resync-interval", 30*time.Second,
+		"How often each Memcached is reconciled and its memcached statistics polled when nothing changes.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The number of Memcached resources reconciled in parallel.")
+	flag.DurationVar(&backoff.BaseDelay, "backoff-base-delay", controllers.DefaultBackoffBaseDelay,
+		"The delay before retrying a failed reconcile, doubled on every consecutive failure.")
+	flag.DurationVar(&backoff.MaxDelay, "backoff-max-delay", controllers.DefaultBackoffMaxDelay,
+		"The maximum delay before retrying a failed reconcile.")
+	flag.Float64Var(&backoff.Jitter, "backoff-jitter", controllers.DefaultBackoffJitter,
+		"The maximum fraction of the retry delay added at random.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", time.Minute,
+		"The maximum duration of a reconcile.")
+	flag.DurationVar(&apiTimeout, "api-timeout", 10*time.Second,
+		"The maximum duration of each request made by the reconciler to the API server.")
+	flag.DurationVar(&memcachedTimeout, "memcached-timeout", 2*time.Second,
+		"The maximum duration of each connection and request made to a memcached pod.")
+	flag.DurationVar(&warmUpTimeout, "warm-up-timeout", 10*time.Minute,
+		"The maximum duration of a warm-up, from the snapshot before a rollout until every new pod is warmed up.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Report the changes the operator would make to the objects of each Memcached in its status and events instead of making them.")
+	logOpts := logging.NewOptions()
+	logOpts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
-	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
+	logger, err := logging.New(logOpts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	ctrl.SetLogger(logger)
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
@@ -61,6 +99,7 @@ func main() {
 		Port:               9443,
 		LeaderElection:     enableLeaderElection,
 		LeaderElectionID:   "86f835c3.example.com",
+		NewCache:           controllers.NewCache,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -68,9 +107,20 @@ func main() {
 	}
 
 	if err = (&controllers.MemcachedReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Memcached"),
-		Scheme: mgr.GetScheme(),
+		Client:    controllers.WithTimeout(mgr.GetClient(), apiTimeout),
+		APIReader: mgr.GetAPIReader(),
+		Log:       ctrl.Log.WithName("controllers").WithName("Memcached"),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("memcached-controller"),
+
+		DiscoveryMinInterval:    endpointsMinInterval,
+		ResyncInterval:          resyncInterval,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		Backoff:                 backoff,
+		ReconcileTimeout:        reconcileTimeout,
+		MemcachedTimeout:        memcachedTimeout,
+		WarmUpTimeout:           warmUpTimeout,
+		DryRun:                  dryRun,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Memcached")
 		os.Exit(1)
@@ -79,6 +129,10 @@ func main() {
 		setupLog.Error(err, "unable to create webhook", "webhook", "Memcached")
 		os.Exit(1)
 	}
+	if err = policy.SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "MemcachedPolicy")
+		os.Exit(1)
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
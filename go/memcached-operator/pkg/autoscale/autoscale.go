@@ -0,0 +1,233 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package autoscale decides the number of memcached replicas from the
+// statistics reported by the servers.
+package autoscale
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/example/memcached-operator/pkg/memcache"
+)
+
+// Policy holds the bounds and targets of the autoscaler. Zero targets are
+// ignored.
+type Policy struct {
+	MinReplicas int32
+	MaxReplicas int32
+
+	// TargetHitRatio is the fraction of gets, between 0 and 1, that should hit.
+	TargetHitRatio float64
+	// TargetMemoryUtilization is the fraction of limit_maxbytes, between 0 and
+	// 1, each server should use.
+	TargetMemoryUtilization float64
+	// TargetConnections is the number of open connections per server.
+	TargetConnections float64
+
+	// ScaleUpStabilization and ScaleDownStabilization are the windows over
+	// which recommendations are smoothed before scaling up or down.
+	ScaleUpStabilization   time.Duration
+	ScaleDownStabilization time.Duration
+	// Cooldown is the minimum time between two scaling operations.
+	Cooldown time.Duration
+}
+
+// Metrics are the observations the autoscaler acts upon. Nil values were not
+// observable, for instance no get was served since the previous poll.
+type Metrics struct {
+	HitRatio          *float64
+	MemoryUtilization *float64
+	Connections       *float64
+}
+
+// Compute derives the metrics from two consecutive polls of the servers, keyed
+// by pod name. The hit ratio is computed over the gets served between the polls.
+func Compute(prev, cur map[string]memcache.ServerStats) Metrics {
+	var m Metrics
+	if len(cur) == 0 {
+		return m
+	}
+
+	var hits, gets, bytes, limit, conns float64
+	for pod, now := range cur {
+		bytes += float64(now.Bytes)
+		limit += float64(now.LimitMaxBytes)
+		conns += float64(now.CurrConnections)
+
+		before, ok := prev[pod]
+		if !ok {
+			continue
+		}
+		dHits, dMisses := now.GetHits, now.GetMisses
+		// Counters go backwards when the server restarted since the last poll.
+		if now.GetHits >= before.GetHits && now.GetMisses >= before.GetMisses {
+			dHits -= before.GetHits
+			dMisses -= before.GetMisses
+		}
+		hits += float64(dHits)
+		gets += float64(dHits + dMisses)
+	}
+
+	if gets > 0 {
+		ratio := hits / gets
+		m.HitRatio = &ratio
+	}
+	if limit > 0 {
+		util := bytes / limit
+		m.MemoryUtilization = &util
+	}
+	perPod := conns / float64(len(cur))
+	m.Connections = &perPod
+	return m
+}
+
+// Recommend returns the number of replicas that brings every metric to its
+// target, like the HorizontalPodAutoscaler does, and the reason for it. The
+// result is kept within the policy bounds and rounded up to an odd number, as
+// required for Memcached sizes.
+func Recommend(p Policy, current int32, m Metrics) (int32, string) {
+	desired := current
+	var reasons []string
+	consider := func(name string, observed *float64, target float64, inverse bool) {
+		if observed == nil || target <= 0 {
+			return
+		}
+		ratio := *observed / target
+		if inverse {
+			// A lower hit ratio than targeted calls for more capacity.
+			if *observed <= 0 {
+				ratio = 2
+			} else {
+				ratio = target / *observed
+			}
+		}
+		n := int32(math.Ceil(float64(current) * ratio))
+		reasons = append(reasons, fmt.Sprintf("%s %.2f/%.2f", name, *observed, target))
+		if n > desired || len(reasons) == 1 {
+			desired = n
+		}
+	}
+	consider("memory utilization", m.MemoryUtilization, p.TargetMemoryUtilization, false)
+	consider("connections", m.Connections, p.TargetConnections, false)
+	consider("hit ratio", m.HitRatio, p.TargetHitRatio, true)
+
+	return clamp(p, desired), strings.Join(reasons, ", ")
+}
+
+func clamp(p Policy, n int32) int32 {
+	if n < p.MinReplicas {
+		n = p.MinReplicas
+	}
+	if p.MaxReplicas > 0 && n > p.MaxReplicas {
+		n = p.MaxReplicas
+	}
+	if n < 1 {
+		n = 1
+	}
+	if n%2 == 0 {
+		if p.MaxReplicas == 0 || n+1 <= p.MaxReplicas {
+			n++
+		} else {
+			n--
+		}
+	}
+	return n
+}
+
+// Decision is the outcome of an evaluation of the autoscaler.
+type Decision struct {
+	// Replicas is the stabilized recommendation.
+	Replicas int32
+	// Scale is true when Replicas should be applied now.
+	Scale  bool
+	Reason string
+}
+
+type recommendation struct {
+	time     time.Time
+	replicas int32
+}
+
+// Scaler smooths recommendations over the stabilization windows and enforces
+// the cooldown. It keeps state between evaluations and must be reused for
+// the same Memcached.
+type Scaler struct {
+	Policy Policy
+	// LastScaleTime is when the replicas were last changed.
+	LastScaleTime time.Time
+
+	history []recommendation
+}
+
+// Decide records the recommendation for the given metrics and returns whether
+// the replicas should change. Scaling up uses the lowest recommendation of the
+// scale-up window and scaling down the highest of the scale-down window, so a
+// single spike or dip never resizes the cache.
+func (s *Scaler) Decide(now time.Time, current int32, m Metrics) Decision {
+	replicas, reason := Recommend(s.Policy, current, m)
+	s.history = append(s.history, recommendation{time: now, replicas: replicas})
+
+	window := s.Policy.ScaleUpStabilization
+	if s.Policy.ScaleDownStabilization > window {
+		window = s.Policy.ScaleDownStabilization
+	}
+	kept := s.history[:0]
+	for _, rec := range s.history {
+		if now.Sub(rec.time) <= window {
+			kept = append(kept, rec)
+		}
+	}
+	s.history = kept
+
+	up, down := replicas, replicas
+	for _, rec := range s.history {
+		age := now.Sub(rec.time)
+		if age <= s.Policy.ScaleUpStabilization && rec.replicas < up {
+			up = rec.replicas
+		}
+		if age <= s.Policy.ScaleDownStabilization && rec.replicas > down {
+			down = rec.replicas
+		}
+	}
+
+	d := Decision{Replicas: current, Reason: reason}
+	switch {
+	case up > current:
+		d.Replicas = up
+	case down < current:
+		d.Replicas = down
+	}
+	// Bounds are always enforced, even during the cooldown.
+	if bounded := clamp(s.Policy, current); bounded != current {
+		d.Replicas = bounded
+		d.Reason = fmt.Sprintf("replicas outside [%d, %d]", s.Policy.MinReplicas, s.Policy.MaxReplicas)
+		d.Scale = true
+		return d
+	}
+	if d.Replicas == current {
+		return d
+	}
+	if !s.LastScaleTime.IsZero() && now.Sub(s.LastScaleTime) < s.Policy.Cooldown {
+		d.Replicas = current
+		return d
+	}
+	d.Scale = true
+	return d
+}
@@ -0,0 +1,137 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package autoscale
+
+import (
+	"testing"
+	"time"
+
+	"github.com/example/memcached-operator/pkg/memcache"
+)
+
+func f(v float64) *float64 { return &v }
+
+func TestCompute(t *testing.T) {
+	prev := map[string]memcache.ServerStats{
+		"a": {GetHits: 100, GetMisses: 100},
+		"b": {GetHits: 500, GetMisses: 500},
+	}
+	cur := map[string]memcache.ServerStats{
+		// 80 hits out of 100 gets since the last poll.
+		"a": {GetHits: 180, GetMisses: 120, Bytes: 30, LimitMaxBytes: 100, CurrConnections: 10},
+		// Restarted: the counters are taken as they are.
+		"b": {GetHits: 60, GetMisses: 40, Bytes: 50, LimitMaxBytes: 100, CurrConnections: 20},
+		// New pod: no previous sample, only gauges count.
+		"c": {GetHits: 999, GetMisses: 1, Bytes: 10, LimitMaxBytes: 100, CurrConnections: 30},
+	}
+	m := Compute(prev, cur)
+	if m.HitRatio == nil || *m.HitRatio != 0.7 {
+		t.Errorf("expected a hit ratio of 0.7, got %v", m.HitRatio)
+	}
+	if m.MemoryUtilization == nil || *m.MemoryUtilization != 0.3 {
+		t.Errorf("expected a memory utilization of 0.3, got %v", m.MemoryUtilization)
+	}
+	if m.Connections == nil || *m.Connections != 20 {
+		t.Errorf("expected 20 connections per pod, got %v", m.Connections)
+	}
+
+	if m := Compute(cur, cur); m.HitRatio != nil {
+		t.Errorf("expected no hit ratio without gets, got %v", *m.HitRatio)
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	policy := Policy{MinReplicas: 1, MaxReplicas: 9, TargetMemoryUtilization: 0.5, TargetConnections: 100, TargetHitRatio: 0.9}
+	tests := []struct {
+		name    string
+		current int32
+		metrics Metrics
+		want    int32
+	}{
+		{"no metrics", 3, Metrics{}, 3},
+		{"memory pressure", 3, Metrics{MemoryUtilization: f(1)}, 7},
+		{"idle scales down", 5, Metrics{MemoryUtilization: f(0.1), Connections: f(10)}, 1},
+		{"highest wins", 3, Metrics{MemoryUtilization: f(0.5), Connections: f(150)}, 5},
+		{"low hit ratio", 3, Metrics{HitRatio: f(0.45)}, 7},
+		{"no hits", 3, Metrics{HitRatio: f(0)}, 7},
+		{"capped at max", 5, Metrics{MemoryUtilization: f(5)}, 9},
+		{"rounded to odd", 1, Metrics{MemoryUtilization: f(1)}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := Recommend(policy, tt.current, tt.metrics); got != tt.want {
+				t.Errorf("got %d replicas, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if got, _ := Recommend(Policy{MinReplicas: 1, MaxReplicas: 4, TargetMemoryUtilization: 0.5}, 3, Metrics{MemoryUtilization: f(1)}); got != 3 {
+		t.Errorf("expected rounding to odd to stay below an even max, got %d", got)
+	}
+}
+
+func TestScalerStabilization(t *testing.T) {
+	s := &Scaler{Policy: Policy{
+		MinReplicas:             1,
+		MaxReplicas:             9,
+		TargetMemoryUtilization: 0.5,
+		ScaleUpStabilization:    time.Minute,
+		ScaleDownStabilization:  5 * time.Minute,
+	}}
+	start := time.Unix(0, 0)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	// A single spike does not scale up while a lower recommendation is in the window.
+	if d := s.Decide(at(0), 3, Metrics{MemoryUtilization: f(0.5)}); d.Scale {
+		t.Fatalf("unexpected scaling %+v", d)
+	}
+	if d := s.Decide(at(30*time.Second), 3, Metrics{MemoryUtilization: f(1)}); d.Scale {
+		t.Fatalf("expected the spike to be smoothed, got %+v", d)
+	}
+	// Once the pressure lasted for the whole window, scale up.
+	d := s.Decide(at(90*time.Second), 3, Metrics{MemoryUtilization: f(1)})
+	if !d.Scale || d.Replicas != 7 {
+		t.Fatalf("expected scaling up to 7, got %+v", d)
+	}
+	s.LastScaleTime = at(90 * time.Second)
+
+	// Scaling down waits for the whole scale-down window.
+	if d := s.Decide(at(2*time.Minute), 7, Metrics{MemoryUtilization: f(0.1)}); d.Scale {
+		t.Fatalf("expected no scale down inside the window, got %+v", d)
+	}
+	d = s.Decide(at(7*time.Minute), 7, Metrics{MemoryUtilization: f(0.1)})
+	if !d.Scale || d.Replicas != 3 {
+		t.Fatalf("expected scaling down to 3 once the window passed, got %+v", d)
+	}
+}
+
+func TestScalerCooldownAndBounds(t *testing.T) {
+	s := &Scaler{Policy: Policy{MinReplicas: 3, MaxReplicas: 9, TargetConnections: 10, Cooldown: 10 * time.Minute}}
+	now := time.Unix(3600, 0)
+	s.LastScaleTime = now.Add(-time.Minute)
+
+	if d := s.Decide(now, 3, Metrics{Connections: f(100)}); d.Scale || d.Replicas != 3 {
+		t.Errorf("expected the cooldown to block scaling, got %+v", d)
+	}
+	if d := s.Decide(now, 1, Metrics{}); !d.Scale || d.Replicas != 3 {
+		t.Errorf("expected the minimum to be enforced during the cooldown, got %+v", d)
+	}
+	now = now.Add(time.Hour)
+	if d := s.Decide(now, 3, Metrics{Connections: f(100)}); !d.Scale || d.Replicas != 9 {
+		t.Errorf("expected scaling to the max after the cooldown, got %+v", d)
+	}
+}
@@ -0,0 +1,223 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package chaos provides a client wrapper injecting API failures, to test
+// that controllers converge when the API server misbehaves.
+package chaos
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Verbs of the requests faults are injected in.
+const (
+	Get    = "get"
+	List   = "list"
+	Create = "create"
+	Update = "update"
+	Patch  = "patch"
+	Delete = "delete"
+	// Status covers the updates and patches of the status subresource.
+	Status = "status"
+)
+
+// Faults are the failures a Client injects, each with the probability of a
+// request failing that way. At most one fault is injected per request.
+type Faults struct {
+	// Errors fails requests with an internal server error, before they reach
+	// the wrapped client.
+	Errors float64
+	// Conflicts fails writes with a conflict, as if the object had been
+	// modified since it was read.
+	Conflicts float64
+	// Partial makes writes time out after they were made, so the caller does
+	// not know whether they were.
+	Partial float64
+	// Latency delays every request by up to this duration, or until its
+	// context is done.
+	Latency time.Duration
+	// Verbs restricts the faults to the requests of these verbs. The faults
+	// apply to every request when empty.
+	Verbs []string
+}
+
+func (f Faults) apply(verb string) bool {
+	if len(f.Verbs) == 0 {
+		return true
+	}
+	for _, v := range f.Verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+// Client wraps a client.Client and injects faults in its requests. The faults
+// are drawn from a seeded source, so a sequence of requests fails the same
+// way on every run. It is safe for concurrent use, though the faults then
+// depend on the order of the requests.
+type Client struct {
+	client.Client
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	faults   Faults
+	injected map[string]int
+}
+
+// New returns a Client injecting no faults until SetFaults is called.
+func New(c client.Client, seed int64) *Client {
+	return &Client{Client: c, rand: rand.New(rand.NewSource(seed)), injected: map[string]int{}}
+}
+
+// SetFaults replaces the injected faults.
+func (c *Client) SetFaults(f Faults) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.faults = f
+}
+
+// Injected returns the number of faults injected in requests of a verb.
+func (c *Client) Injected(verb string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.injected[verb]
+}
+
+// fault is what happens to a request.
+type fault int
+
+const (
+	none fault = iota
+	failure
+	conflict
+	partial
+)
+
+// draw picks the fault of a request and waits for its latency.
+func (c *Client) draw(ctx context.Context, verb string, write bool) fault {
+	c.mu.Lock()
+	f := c.faults
+	if !f.apply(verb) {
+		c.mu.Unlock()
+		return none
+	}
+	var latency time.Duration
+	if f.Latency > 0 {
+		latency = time.Duration(c.rand.Int63n(int64(f.Latency)))
+	}
+	p := c.rand.Float64()
+	result := none
+	switch {
+	case p < f.Errors:
+		result = failure
+	case !write:
+	case p < f.Errors+f.Conflicts:
+		result = conflict
+	case p < f.Errors+f.Conflicts+f.Partial:
+		result = partial
+	}
+	if result != none {
+		c.injected[verb]++
+	}
+	c.mu.Unlock()
+
+	if latency > 0 {
+		t := time.NewTimer(latency)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+		}
+	}
+	return result
+}
+
+// do runs a request with the fault drawn for it.
+func (c *Client) do(ctx context.Context, verb string, write bool, obj runtime.Object, request func() error) error {
+	switch c.draw(ctx, verb, write) {
+	case failure:
+		return errors.NewInternalError(fmt.Errorf("chaos: injected %s failure", verb))
+	case conflict:
+		return errors.NewConflict(schema.GroupResource{}, nameOf(obj), fmt.Errorf("chaos: injected %s conflict", verb))
+	case partial:
+		if err := request(); err != nil {
+			return err
+		}
+		return errors.NewTimeoutError(fmt.Sprintf("chaos: injected timeout after %s", verb), 0)
+	}
+	return request()
+}
+
+func nameOf(obj runtime.Object) string {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	return accessor.GetName()
+}
+
+func (c *Client) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	return c.do(ctx, Get, false, obj, func() error { return c.Client.Get(ctx, key, obj) })
+}
+
+func (c *Client) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	return c.do(ctx, List, false, list, func() error { return c.Client.List(ctx, list, opts...) })
+}
+
+func (c *Client) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	return c.do(ctx, Create, true, obj, func() error { return c.Client.Create(ctx, obj, opts...) })
+}
+
+func (c *Client) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return c.do(ctx, Update, true, obj, func() error { return c.Client.Update(ctx, obj, opts...) })
+}
+
+func (c *Client) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return c.do(ctx, Patch, true, obj, func() error { return c.Client.Patch(ctx, obj, patch, opts...) })
+}
+
+func (c *Client) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	return c.do(ctx, Delete, true, obj, func() error { return c.Client.Delete(ctx, obj, opts...) })
+}
+
+func (c *Client) Status() client.StatusWriter {
+	return &statusWriter{StatusWriter: c.Client.Status(), c: c}
+}
+
+type statusWriter struct {
+	client.StatusWriter
+	c *Client
+}
+
+func (w *statusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return w.c.do(ctx, Status, true, obj, func() error { return w.StatusWriter.Update(ctx, obj, opts...) })
+}
+
+func (w *statusWriter) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return w.c.do(ctx, Status, true, obj, func() error { return w.StatusWriter.Patch(ctx, obj, patch, opts...) })
+}
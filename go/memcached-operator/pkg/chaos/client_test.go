@@ -0,0 +1,112 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaos
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func configMap(name string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+}
+
+func TestFaults(t *testing.T) {
+	key := types.NamespacedName{Name: "a", Namespace: "default"}
+	tests := []struct {
+		name    string
+		faults  Faults
+		check   func(error) bool
+		created bool
+	}{
+		{"none", Faults{}, func(err error) bool { return err == nil }, true},
+		{"errors", Faults{Errors: 1}, errors.IsInternalError, false},
+		{"conflicts", Faults{Conflicts: 1}, errors.IsConflict, false},
+		{"partial", Faults{Partial: 1}, errors.IsTimeout, true},
+		{"other verbs", Faults{Errors: 1, Verbs: []string{Delete}}, func(err error) bool { return err == nil }, true},
+	}
+	for _, tt := range tests {
+		c := New(fake.NewFakeClient(), 1)
+		c.SetFaults(tt.faults)
+		if err := c.Create(context.TODO(), configMap("a")); !tt.check(err) {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		c.SetFaults(Faults{})
+		err := c.Get(context.TODO(), key, &corev1.ConfigMap{})
+		if created := err == nil; created != tt.created {
+			t.Errorf("%s: expected created %v, got error %v", tt.name, tt.created, err)
+		}
+	}
+}
+
+func TestConflictsOnlyFailWrites(t *testing.T) {
+	c := New(fake.NewFakeClient(configMap("a")), 1)
+	c.SetFaults(Faults{Conflicts: 1, Partial: 1})
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "a", Namespace: "default"}, &corev1.ConfigMap{}); err != nil {
+		t.Errorf("expected reads to succeed, got %v", err)
+	}
+	if err := c.List(context.TODO(), &corev1.ConfigMapList{}); err != nil {
+		t.Errorf("expected lists to succeed, got %v", err)
+	}
+	if err := c.Status().Update(context.TODO(), configMap("a")); !errors.IsConflict(err) {
+		t.Errorf("expected a status conflict, got %v", err)
+	}
+	if got := c.Injected(Status); got != 1 {
+		t.Errorf("expected 1 injected status fault, got %d", got)
+	}
+	if got := c.Injected(Get); got != 0 {
+		t.Errorf("expected no injected get fault, got %d", got)
+	}
+}
+
+func TestSeededFaultsAreReproducible(t *testing.T) {
+	run := func() []bool {
+		c := New(fake.NewFakeClient(configMap("a")), 42)
+		c.SetFaults(Faults{Errors: 0.5})
+		var failed []bool
+		for i := 0; i < 20; i++ {
+			err := c.Get(context.TODO(), types.NamespacedName{Name: "a", Namespace: "default"}, &corev1.ConfigMap{})
+			failed = append(failed, err != nil)
+		}
+		return failed
+	}
+	first, second := run(), run()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected the same faults with the same seed, got %v and %v", first, second)
+		}
+	}
+}
+
+func TestLatencyStopsWithContext(t *testing.T) {
+	c := New(fake.NewFakeClient(configMap("a")), 1)
+	c.SetFaults(Faults{Latency: time.Hour})
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	c.Get(ctx, types.NamespacedName{Name: "a", Namespace: "default"}, &corev1.ConfigMap{})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the request to stop with its context, took %v", elapsed)
+	}
+}
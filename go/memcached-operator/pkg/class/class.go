@@ -0,0 +1,210 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package class applies the presets of a MemcachedClass to the Memcached
+// resources of the class. The presets only fill what the spec of a Memcached
+// leaves unset, so a Memcached can still override any of them.
+package class
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+)
+
+const (
+	// ExporterDefaultImage is the image of the exporter of monitored classes
+	// that do not set one.
+	ExporterDefaultImage = "prom/memcached-exporter:v0.8.0"
+
+	// ExporterPort is the port the exporter serves metrics on.
+	ExporterPort = 9150
+)
+
+// NotFoundError is returned when the class named by a Memcached does not
+// exist.
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("MemcachedClass %s not found", e.Name)
+}
+
+// Select returns the class of m among classes: the one named by its
+// className, or else the default class. When several classes are marked
+// default, the most recently created one is used, as Kubernetes does for
+// storage classes. It returns nil when m has no class.
+func Select(classes []cachev1alpha1.MemcachedClass, m *cachev1alpha1.Memcached) (*cachev1alpha1.MemcachedClass, error) {
+	if m.Spec.ClassName != "" {
+		for i := range classes {
+			if classes[i].Name == m.Spec.ClassName {
+				return &classes[i], nil
+			}
+		}
+		return nil, &NotFoundError{Name: m.Spec.ClassName}
+	}
+	var selected *cachev1alpha1.MemcachedClass
+	for i := range classes {
+		c := &classes[i]
+		if !IsDefault(c) {
+			continue
+		}
+		if selected == nil || selected.CreationTimestamp.Before(&c.CreationTimestamp) ||
+			(selected.CreationTimestamp.Equal(&c.CreationTimestamp) && c.Name < selected.Name) {
+			selected = c
+		}
+	}
+	return selected, nil
+}
+
+// IsDefault returns whether c is marked as the default class.
+func IsDefault(c *cachev1alpha1.MemcachedClass) bool {
+	isDefault, _ := strconv.ParseBool(c.Annotations[cachev1alpha1.DefaultClassAnnotation])
+	return isDefault
+}
+
+// Resolve returns the class of m read from c, or nil when m has none.
+func Resolve(ctx context.Context, c client.Reader, m *cachev1alpha1.Memcached) (*cachev1alpha1.MemcachedClass, error) {
+	classes := &cachev1alpha1.MemcachedClassList{}
+	if err := c.List(ctx, classes); err != nil {
+		return nil, err
+	}
+	return Select(classes.Items, m)
+}
+
+// Apply returns a copy of m with the presets of c applied, or m itself when c
+// is nil. The memory of c is used when m sets none, and the other presets are
+// turned into a pod template patch applied before the podTemplate of m.
+func Apply(m *cachev1alpha1.Memcached, c *cachev1alpha1.MemcachedClass) (*cachev1alpha1.Memcached, error) {
+	if c == nil {
+		return m, nil
+	}
+	out := m.DeepCopy()
+	if out.Spec.Memory == nil && c.Spec.Memory != nil {
+		memory := c.Spec.Memory.DeepCopy()
+		out.Spec.Memory = &memory
+	}
+
+	patch, err := presetPatch(&c.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("MemcachedClass %s: %v", c.Name, err)
+	}
+	if len(patch) == 0 {
+		return out, nil
+	}
+	if m.Spec.PodTemplate != nil {
+		own := strategicpatch.JSONMap{}
+		if err := json.Unmarshal(m.Spec.PodTemplate.Raw, &own); err != nil {
+			return nil, fmt.Errorf("invalid podTemplate: %v", err)
+		}
+		schema, err := strategicpatch.NewPatchMetaFromStruct(corev1.PodTemplateSpec{})
+		if err != nil {
+			return nil, err
+		}
+		if patch, err = strategicpatch.MergeStrategicMergeMapPatchUsingLookupPatchMeta(schema, patch, own); err != nil {
+			return nil, fmt.Errorf("MemcachedClass %s: %v", c.Name, err)
+		}
+	}
+	raw, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	out.Spec.PodTemplate = &runtime.RawExtension{Raw: raw}
+	return out, nil
+}
+
+// presetPatch returns the pod template patch of the presets of a class.
+func presetPatch(spec *cachev1alpha1.MemcachedClassSpec) (strategicpatch.JSONMap, error) {
+	template := corev1.PodTemplateSpec{}
+	memcached := corev1.Container{Name: "memcached", Image: spec.Image}
+	if spec.Resources != nil {
+		memcached.Resources = *spec.Resources
+	}
+	if memcached.Image != "" || spec.Resources != nil {
+		template.Spec.Containers = append(template.Spec.Containers, memcached)
+	}
+	if s := spec.Scheduling; s != nil {
+		template.Spec.NodeSelector = s.NodeSelector
+		template.Spec.Tolerations = s.Tolerations
+		template.Spec.Affinity = s.Affinity
+		template.Spec.PriorityClassName = s.PriorityClassName
+	}
+	if mon := spec.Monitoring; mon != nil && mon.Enabled {
+		image := mon.ExporterImage
+		if image == "" {
+			image = ExporterDefaultImage
+		}
+		template.Annotations = map[string]string{
+			"prometheus.io/scrape": "true",
+			"prometheus.io/port":   strconv.Itoa(ExporterPort),
+		}
+		template.Spec.Containers = append(template.Spec.Containers, corev1.Container{
+			Name:  "exporter",
+			Image: image,
+			Ports: []corev1.ContainerPort{{Name: "metrics", ContainerPort: ExporterPort}},
+		})
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&template)
+	if err != nil {
+		return nil, err
+	}
+	return strategicpatch.JSONMap(prune(content).(map[string]interface{})), nil
+}
+
+// prune removes the null and empty fields of v, which would delete fields in
+// a patch.
+func prune(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, item := range t {
+			item = prune(item)
+			if empty(item) {
+				delete(t, k)
+			} else {
+				t[k] = item
+			}
+		}
+	case []interface{}:
+		for i, item := range t {
+			t[i] = prune(item)
+		}
+	}
+	return v
+}
+
+func empty(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(t) == 0
+	case []interface{}:
+		return len(t) == 0
+	case string:
+		return t == ""
+	}
+	return false
+}
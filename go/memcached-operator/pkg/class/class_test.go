@@ -0,0 +1,165 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package class
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/resources"
+)
+
+func memcachedClass(name string, isDefault bool, created time.Time) cachev1alpha1.MemcachedClass {
+	c := cachev1alpha1.MemcachedClass{ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)}}
+	if isDefault {
+		c.Annotations = map[string]string{cachev1alpha1.DefaultClassAnnotation: "true"}
+	}
+	return c
+}
+
+func TestSelect(t *testing.T) {
+	now := time.Now()
+	small := memcachedClass("small", false, now)
+	standard := memcachedClass("standard", true, now.Add(-time.Hour))
+	large := memcachedClass("large", true, now)
+
+	tests := []struct {
+		name      string
+		classes   []cachev1alpha1.MemcachedClass
+		className string
+		want      string
+		err       string
+	}{
+		{name: "no classes", want: ""},
+		{name: "no default", classes: []cachev1alpha1.MemcachedClass{small}, want: ""},
+		{name: "default", classes: []cachev1alpha1.MemcachedClass{small, standard}, want: "standard"},
+		{name: "newest default", classes: []cachev1alpha1.MemcachedClass{large, small, standard}, want: "large"},
+		{name: "named", classes: []cachev1alpha1.MemcachedClass{small, standard}, className: "small", want: "small"},
+		{name: "not found", classes: []cachev1alpha1.MemcachedClass{standard}, className: "huge", err: "MemcachedClass huge not found"},
+	}
+	for _, tt := range tests {
+		m := &cachev1alpha1.Memcached{Spec: cachev1alpha1.MemcachedSpec{ClassName: tt.className}}
+		got, err := Select(tt.classes, m)
+		if tt.err != "" {
+			if _, ok := err.(*NotFoundError); !ok || err.Error() != tt.err {
+				t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		name := ""
+		if got != nil {
+			name = got.Name
+		}
+		if name != tt.want {
+			t.Errorf("%s: expected class %q, got %q", tt.name, tt.want, name)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	memory := resource.MustParse("256Mi")
+	c := &cachev1alpha1.MemcachedClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "standard"},
+		Spec: cachev1alpha1.MemcachedClassSpec{
+			Image:  "memcached:1.6.9-alpine",
+			Memory: &memory,
+			Resources: &corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("300Mi")},
+			},
+			Scheduling: &cachev1alpha1.SchedulingSpec{
+				NodeSelector:      map[string]string{"pool": "cache"},
+				PriorityClassName: "cache",
+			},
+			Monitoring: &cachev1alpha1.MonitoringSpec{Enabled: true},
+		},
+	}
+	m := &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default"},
+		Spec:       cachev1alpha1.MemcachedSpec{Size: 3},
+	}
+
+	effective, err := Apply(m, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Spec.Memory != nil || m.Spec.PodTemplate != nil {
+		t.Errorf("expected the Memcached to be left alone, got %+v", m.Spec)
+	}
+	dep, err := resources.MemcachedDeployment(effective, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := dep.Spec.Template
+	containers := pod.Spec.Containers
+	if len(containers) != 2 || containers[0].Name != "memcached" || containers[1].Name != "exporter" {
+		t.Fatalf("expected the memcached and exporter containers, got %+v", containers)
+	}
+	if containers[0].Image != "memcached:1.6.9-alpine" || containers[0].Command[1] != "-m=256" {
+		t.Errorf("expected the image and memory of the class, got %s %v", containers[0].Image, containers[0].Command)
+	}
+	if limit := containers[0].Resources.Limits[corev1.ResourceMemory]; limit.String() != "300Mi" {
+		t.Errorf("expected the resources of the class, got %+v", containers[0].Resources)
+	}
+	if containers[1].Image != ExporterDefaultImage || containers[1].Ports[0].ContainerPort != ExporterPort {
+		t.Errorf("unexpected exporter %+v", containers[1])
+	}
+	if pod.Annotations["prometheus.io/scrape"] != "true" || pod.Annotations["prometheus.io/port"] != "9150" {
+		t.Errorf("expected the scrape annotations, got %v", pod.Annotations)
+	}
+	if pod.Spec.NodeSelector["pool"] != "cache" || pod.Spec.PriorityClassName != "cache" {
+		t.Errorf("expected the scheduling of the class, got %+v", pod.Spec)
+	}
+
+	// The spec and the pod template of the Memcached override the class.
+	override := resource.MustParse("1Gi")
+	m.Spec.Memory = &override
+	m.Spec.PodTemplate = &runtime.RawExtension{Raw: []byte(`{"spec":{"nodeSelector":{"pool":"fast"},"containers":[{"name":"memcached","image":"memcached:1.6.10"}]}}`)}
+	if effective, err = Apply(m, c); err != nil {
+		t.Fatal(err)
+	}
+	if dep, err = resources.MemcachedDeployment(effective, nil); err != nil {
+		t.Fatal(err)
+	}
+	pod = dep.Spec.Template
+	if image := pod.Spec.Containers[0].Image; image != "memcached:1.6.10" {
+		t.Errorf("expected the image of the Memcached, got %s", image)
+	}
+	if command := strings.Join(pod.Spec.Containers[0].Command, " "); !strings.Contains(command, "-m=1024") {
+		t.Errorf("expected the memory of the Memcached, got %s", command)
+	}
+	if limit := pod.Spec.Containers[0].Resources.Limits[corev1.ResourceMemory]; limit.String() != "300Mi" {
+		t.Errorf("expected the resources of the class to be kept, got %+v", pod.Spec.Containers[0].Resources)
+	}
+	if pod.Spec.NodeSelector["pool"] != "fast" || len(pod.Spec.Containers) != 2 {
+		t.Errorf("expected the pod template of the Memcached on top of the class, got %+v", pod.Spec)
+	}
+
+	if got, err := Apply(m, nil); err != nil || got != m {
+		t.Errorf("expected a Memcached without a class to be returned as is, got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,404 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package clustersim simulates the parts of a cluster that envtest and the
+// fake client lack: the Deployment and StatefulSet controllers creating Pods
+// from their templates, and the kubelet running them. Nothing happens in the
+// background: tests step the simulation with Sync and decide when pods become
+// ready, so the status and rollout logic of the reconciler can be tested
+// deterministically.
+package clustersim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DeploymentHashLabel holds the hash of the template a Deployment pod
+	// was created from, as set by the ReplicaSets of a cluster.
+	DeploymentHashLabel = "pod-template-hash"
+
+	// StatefulSetRevisionLabel holds the revision of the template a
+	// StatefulSet pod was created from.
+	StatefulSetRevisionLabel = "controller-revision-hash"
+)
+
+// Cluster runs the simulated controllers and kubelet against the objects
+// of a client.
+//
+// Deployments are rolled by creating all the pods of the new template at
+// once, and deleting the pods of the old templates as the new ones become
+// ready, so the ready pods never drop below the replicas. Their pods are
+// controlled by the ReplicaSet of their template, named after the Deployment
+// and the template hash like in a cluster, but the ReplicaSets themselves
+// are not created.
+// StatefulSets create and update their pods one at a time in ordinal order,
+// like the OrderedReady policy.
+type Cluster struct {
+	client.Client
+
+	// Namespace restricts the simulation to a namespace. All namespaces are
+	// simulated when empty.
+	Namespace string
+
+	// PodIP returns the IP of the n-th pod started by the kubelet, from 1.
+	// Defaults to addresses of 10.0.0.0/16.
+	PodIP func(n int) string
+
+	created int
+	started int
+}
+
+// New returns a Cluster simulating the objects of c.
+func New(c client.Client) *Cluster {
+	return &Cluster{Client: c}
+}
+
+// Sync runs the Deployment and StatefulSet controllers once: it creates and
+// deletes pods towards the spec of every Deployment and StatefulSet, and
+// updates their status.
+func (c *Cluster) Sync(ctx context.Context) error {
+	deployments := &appsv1.DeploymentList{}
+	if err := c.List(ctx, deployments, client.InNamespace(c.Namespace)); err != nil {
+		return err
+	}
+	for i := range deployments.Items {
+		if err := c.syncDeployment(ctx, &deployments.Items[i]); err != nil {
+			return err
+		}
+	}
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := c.List(ctx, statefulSets, client.InNamespace(c.Namespace)); err != nil {
+		return err
+	}
+	for i := range statefulSets.Items {
+		if err := c.syncStatefulSet(ctx, &statefulSets.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetReady runs or stops the readiness of the named pod, as the kubelet does
+// when its probes pass or fail. A pod gets its IP the first time it is ready.
+func (c *Cluster) SetReady(ctx context.Context, key types.NamespacedName, ready bool) error {
+	pod := &corev1.Pod{}
+	if err := c.Get(ctx, key, pod); err != nil {
+		return err
+	}
+	return c.setReady(ctx, pod, ready)
+}
+
+// SetAllReady makes every pod of the simulated namespaces ready, and returns
+// how many became so.
+func (c *Cluster) SetAllReady(ctx context.Context) (int, error) {
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(c.Namespace)); err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if Ready(pod) {
+			continue
+		}
+		if err := c.setReady(ctx, pod, true); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Ready returns whether the Ready condition of pod is true.
+func Ready(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func (c *Cluster) setReady(ctx context.Context, pod *corev1.Pod, ready bool) error {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+		pod.Status.Phase = corev1.PodRunning
+		if pod.Status.PodIP == "" {
+			c.started++
+			pod.Status.PodIP = c.podIP(c.started)
+		}
+	}
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}
+	return c.Status().Update(ctx, pod)
+}
+
+func (c *Cluster) podIP(n int) string {
+	if c.PodIP != nil {
+		return c.PodIP(n)
+	}
+	return fmt.Sprintf("10.0.%d.%d", n/256, n%256)
+}
+
+func (c *Cluster) syncDeployment(ctx context.Context, dep *appsv1.Deployment) error {
+	pods, err := c.ownedPods(ctx, dep.Namespace, dep.Spec.Selector, func(ref *metav1.OwnerReference, pod *corev1.Pod) bool {
+		return ref.Kind == "ReplicaSet" && ref.Name == replicaSetName(dep, pod.Labels[DeploymentHashLabel])
+	})
+	if err != nil {
+		return err
+	}
+	replicas := replicasOf(dep.Spec.Replicas)
+	hash := templateHash(&dep.Spec.Template)
+
+	var current, old []corev1.Pod
+	for _, pod := range pods {
+		if pod.Labels[DeploymentHashLabel] == hash {
+			current = append(current, pod)
+		} else {
+			old = append(old, pod)
+		}
+	}
+	for i := len(current); i < replicas; i++ {
+		name := fmt.Sprintf("%s-%s-%d", dep.Name, hash, c.created+1)
+		pod, err := c.createPod(ctx, replicaSetRef(dep, hash), dep.Namespace, name, &dep.Spec.Template, DeploymentHashLabel, hash)
+		if err != nil {
+			return err
+		}
+		current = append(current, *pod)
+	}
+	for len(current) > replicas {
+		if err := c.deletePod(ctx, &current[len(current)-1]); err != nil {
+			return err
+		}
+		current = current[:len(current)-1]
+	}
+
+	// Keep the old pods still needed to have as many ready pods as replicas.
+	ready := countReady(current)
+	for len(old) > 0 && len(old) > replicas-ready {
+		if err := c.deletePod(ctx, &old[0]); err != nil {
+			return err
+		}
+		old = old[1:]
+	}
+
+	dep.Status.ObservedGeneration = dep.Generation
+	dep.Status.Replicas = int32(len(current) + len(old))
+	dep.Status.UpdatedReplicas = int32(len(current))
+	dep.Status.ReadyReplicas = int32(ready + countReady(old))
+	dep.Status.AvailableReplicas = dep.Status.ReadyReplicas
+	dep.Status.UnavailableReplicas = dep.Status.Replicas - dep.Status.ReadyReplicas
+	return c.Status().Update(ctx, dep)
+}
+
+func (c *Cluster) syncStatefulSet(ctx context.Context, sts *appsv1.StatefulSet) error {
+	pods, err := c.ownedPods(ctx, sts.Namespace, sts.Spec.Selector, func(ref *metav1.OwnerReference, _ *corev1.Pod) bool {
+		return ref.Kind == "StatefulSet" && ref.Name == sts.Name
+	})
+	if err != nil {
+		return err
+	}
+	replicas := replicasOf(sts.Spec.Replicas)
+	revision := templateHash(&sts.Spec.Template)
+	byName := map[string]corev1.Pod{}
+	for _, pod := range pods {
+		byName[pod.Name] = pod
+	}
+
+	// Remove the pods beyond the replicas, then create the missing ones in
+	// order, each once its predecessors are ready.
+	for _, pod := range pods {
+		if ordinalOf(sts.Name, pod.Name) >= replicas {
+			if err := c.deletePod(ctx, &pod); err != nil {
+				return err
+			}
+			delete(byName, pod.Name)
+		}
+	}
+	for i := 0; i < replicas; i++ {
+		name := fmt.Sprintf("%s-%d", sts.Name, i)
+		pod, ok := byName[name]
+		if !ok {
+			ref := metav1.NewControllerRef(sts, appsv1.SchemeGroupVersion.WithKind("StatefulSet"))
+			created, err := c.createPod(ctx, *ref, sts.Namespace, name, &sts.Spec.Template, StatefulSetRevisionLabel, revision)
+			if err != nil {
+				return err
+			}
+			byName[name] = *created
+			break
+		}
+		if !Ready(&pod) {
+			break
+		}
+	}
+
+	// Replace the pod of the highest ordinal with an old revision once all
+	// the pods are ready.
+	var ready, updated int
+	outdated := ""
+	for i := 0; i < replicas; i++ {
+		pod, ok := byName[fmt.Sprintf("%s-%d", sts.Name, i)]
+		if !ok {
+			continue
+		}
+		if Ready(&pod) {
+			ready++
+		}
+		if pod.Labels[StatefulSetRevisionLabel] == revision {
+			updated++
+		} else {
+			outdated = pod.Name
+		}
+	}
+	if outdated != "" && ready == replicas {
+		pod := byName[outdated]
+		if err := c.deletePod(ctx, &pod); err != nil {
+			return err
+		}
+		delete(byName, outdated)
+		ready--
+	}
+
+	sts.Status.ObservedGeneration = sts.Generation
+	sts.Status.Replicas = int32(len(byName))
+	sts.Status.ReadyReplicas = int32(ready)
+	sts.Status.CurrentReplicas = int32(len(byName))
+	sts.Status.UpdatedReplicas = int32(updated)
+	sts.Status.UpdateRevision = revision
+	if updated == replicas {
+		sts.Status.CurrentRevision = revision
+	}
+	return c.Status().Update(ctx, sts)
+}
+
+// replicaSetName returns the name of the ReplicaSet of dep for the template
+// with the given hash.
+func replicaSetName(dep *appsv1.Deployment, hash string) string {
+	return dep.Name + "-" + hash
+}
+
+// replicaSetRef returns the controller reference of the ReplicaSet of dep
+// for the template with the given hash.
+func replicaSetRef(dep *appsv1.Deployment, hash string) metav1.OwnerReference {
+	rs := &metav1.ObjectMeta{Name: replicaSetName(dep, hash), UID: dep.UID + types.UID("-"+hash)}
+	return *metav1.NewControllerRef(rs, appsv1.SchemeGroupVersion.WithKind("ReplicaSet"))
+}
+
+// ownedPods returns the pods matching selector whose controller reference
+// is accepted by owned, oldest first.
+func (c *Cluster) ownedPods(ctx context.Context, namespace string, selector *metav1.LabelSelector, owned func(ref *metav1.OwnerReference, pod *corev1.Pod) bool) ([]corev1.Pod, error) {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	list := &corev1.PodList{}
+	if err := c.List(ctx, list, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: s}); err != nil {
+		return nil, err
+	}
+	var pods []corev1.Pod
+	for _, pod := range list.Items {
+		ref := metav1.GetControllerOf(&pod)
+		if pod.DeletionTimestamp == nil && ref != nil && owned(ref, &pod) {
+			pods = append(pods, pod)
+		}
+	}
+	sort.SliceStable(pods, func(i, j int) bool {
+		return pods[i].Annotations[createdAnnotation] < pods[j].Annotations[createdAnnotation]
+	})
+	return pods, nil
+}
+
+// createdAnnotation orders the pods by creation, as the creation timestamps
+// of the fake client are not set and those of an API server are rounded to
+// the second.
+const createdAnnotation = "clustersim/created"
+
+func (c *Cluster) createPod(ctx context.Context, owner metav1.OwnerReference, namespace, name string, template *corev1.PodTemplateSpec, hashLabel, hash string) (*corev1.Pod, error) {
+	pod := &corev1.Pod{
+		ObjectMeta: *template.ObjectMeta.DeepCopy(),
+		Spec:       *template.Spec.DeepCopy(),
+	}
+	pod.Name = name
+	pod.Namespace = namespace
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[hashLabel] = hash
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	c.created++
+	pod.Annotations[createdAnnotation] = fmt.Sprintf("%09d", c.created)
+	pod.OwnerReferences = []metav1.OwnerReference{owner}
+	pod.Status.Phase = corev1.PodPending
+	if err := c.Create(ctx, pod); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+func (c *Cluster) deletePod(ctx context.Context, pod *corev1.Pod) error {
+	return client.IgnoreNotFound(c.Delete(ctx, pod))
+}
+
+func countReady(pods []corev1.Pod) int {
+	n := 0
+	for i := range pods {
+		if Ready(&pods[i]) {
+			n++
+		}
+	}
+	return n
+}
+
+func replicasOf(replicas *int32) int {
+	if replicas == nil {
+		return 1
+	}
+	return int(*replicas)
+}
+
+// ordinalOf returns the ordinal of a StatefulSet pod, or -1.
+func ordinalOf(set, pod string) int {
+	var n int
+	if len(pod) <= len(set) {
+		return -1
+	}
+	if _, err := fmt.Sscanf(pod[len(set):], "-%d", &n); err != nil {
+		return -1
+	}
+	return n
+}
+
+// templateHash returns a short hash of a pod template, which changes with
+// any field of the template.
+func templateHash(template *corev1.PodTemplateSpec) string {
+	data, _ := json.Marshal(template)
+	h := fnv.New32a()
+	h.Write(data)
+	return fmt.Sprintf("%08x", h.Sum32())[:8]
+}
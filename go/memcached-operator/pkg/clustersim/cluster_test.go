@@ -0,0 +1,246 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clustersim
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var labels = map[string]string{"app": "memcached"}
+
+func template(image string) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: labels},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "memcached", Image: image}}},
+	}
+}
+
+func newCluster(t *testing.T, objs ...runtime.Object) *Cluster {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return New(NewFakeClient(scheme, objs...))
+}
+
+// podsOf returns the images of the pods sorted by name, with "+" appended
+// to the ready ones.
+func podsOf(t *testing.T, c *Cluster) []string {
+	pods := &corev1.PodList{}
+	if err := c.List(context.TODO(), pods, client.InNamespace("default")); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(pods.Items, func(i, j int) bool { return pods.Items[i].Name < pods.Items[j].Name })
+	var got []string
+	for _, pod := range pods.Items {
+		s := pod.Spec.Containers[0].Image
+		if Ready(&pod) {
+			s += "+"
+		}
+		got = append(got, s)
+	}
+	return got
+}
+
+func sync(t *testing.T, c *Cluster) {
+	if err := c.Sync(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setAllReady(t *testing.T, c *Cluster) {
+	if _, err := c.SetAllReady(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func count(images []string, image string) int {
+	n := 0
+	for _, i := range images {
+		if i == image {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDeploymentRollout(t *testing.T) {
+	replicas := int32(3)
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: template("v1"),
+		},
+	}
+	c := newCluster(t, dep)
+	ctx := context.TODO()
+
+	sync(t, c)
+	if got := podsOf(t, c); count(got, "v1") != 3 {
+		t.Fatalf("expected 3 pending v1 pods, got %v", got)
+	}
+	setAllReady(t, c)
+	sync(t, c)
+	if got := podsOf(t, c); count(got, "v1+") != 3 {
+		t.Fatalf("expected 3 ready v1 pods, got %v", got)
+	}
+	found := &appsv1.Deployment{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "cache", Namespace: "default"}, found); err != nil {
+		t.Fatal(err)
+	}
+	if found.Status.ReadyReplicas != 3 || found.Status.UpdatedReplicas != 3 {
+		t.Errorf("unexpected status %+v", found.Status)
+	}
+
+	// The old pods stay until the new ones are ready.
+	found.Spec.Template = template("v2")
+	if err := c.Update(ctx, found); err != nil {
+		t.Fatal(err)
+	}
+	sync(t, c)
+	if got := podsOf(t, c); count(got, "v1+") != 3 || count(got, "v2") != 3 {
+		t.Fatalf("expected the v2 pods next to the ready v1 pods, got %v", got)
+	}
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace("default")); err != nil {
+		t.Fatal(err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Spec.Containers[0].Image == "v2" {
+			if err := c.SetReady(ctx, types.NamespacedName{Name: pod.Name, Namespace: "default"}, true); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	sync(t, c)
+	if got := podsOf(t, c); count(got, "v1+") != 2 || count(got, "v2+") != 1 || count(got, "v2") != 2 {
+		t.Fatalf("expected an old pod to be replaced by the ready new one, got %v", got)
+	}
+	setAllReady(t, c)
+	sync(t, c)
+	if got := podsOf(t, c); count(got, "v2+") != 3 || len(got) != 3 {
+		t.Fatalf("expected the rollout to complete, got %v", got)
+	}
+
+	// Scaling down removes pods.
+	if err := c.Get(ctx, types.NamespacedName{Name: "cache", Namespace: "default"}, found); err != nil {
+		t.Fatal(err)
+	}
+	replicas = 1
+	found.Spec.Replicas = &replicas
+	if err := c.Update(ctx, found); err != nil {
+		t.Fatal(err)
+	}
+	sync(t, c)
+	if got := podsOf(t, c); len(got) != 1 {
+		t.Fatalf("expected a single pod, got %v", got)
+	}
+}
+
+func TestStatefulSetRollout(t *testing.T) {
+	replicas := int32(3)
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: template("v1"),
+		},
+	}
+	c := newCluster(t, sts)
+	ctx := context.TODO()
+
+	// The pods are created one at a time, each once the previous is ready.
+	for i := 1; i <= 3; i++ {
+		sync(t, c)
+		if got := podsOf(t, c); len(got) != i {
+			t.Fatalf("expected %d pods, got %v", i, got)
+		}
+		setAllReady(t, c)
+	}
+	pod := &corev1.Pod{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "cache-2", Namespace: "default"}, pod); err != nil {
+		t.Fatalf("expected pods named by ordinal: %v", err)
+	}
+
+	found := &appsv1.StatefulSet{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "cache", Namespace: "default"}, found); err != nil {
+		t.Fatal(err)
+	}
+	found.Spec.Template = template("v2")
+	if err := c.Update(ctx, found); err != nil {
+		t.Fatal(err)
+	}
+	// Each sync replaces the highest outdated ordinal, then recreates it.
+	for i := 0; i < 3; i++ {
+		sync(t, c)
+		sync(t, c)
+		setAllReady(t, c)
+	}
+	sync(t, c)
+	if got := podsOf(t, c); count(got, "v2+") != 3 {
+		t.Fatalf("expected the rollout to complete, got %v", got)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Name: "cache", Namespace: "default"}, found); err != nil {
+		t.Fatal(err)
+	}
+	if found.Status.UpdatedReplicas != 3 || found.Status.ReadyReplicas != 3 || found.Status.CurrentRevision != found.Status.UpdateRevision {
+		t.Errorf("unexpected status %+v", found.Status)
+	}
+}
+
+func TestPodIPs(t *testing.T) {
+	replicas := int32(2)
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: template("v1"),
+		},
+	}
+	c := newCluster(t, dep)
+	c.PodIP = func(n int) string { return []string{"", "127.0.0.1", "127.0.0.2"}[n] }
+	sync(t, c)
+	setAllReady(t, c)
+
+	pods := &corev1.PodList{}
+	if err := c.List(context.TODO(), pods); err != nil {
+		t.Fatal(err)
+	}
+	var ips []string
+	for _, pod := range pods.Items {
+		ips = append(ips, pod.Status.PodIP)
+	}
+	sort.Strings(ips)
+	if len(ips) != 2 || ips[0] != "127.0.0.1" || ips[1] != "127.0.0.2" {
+		t.Errorf("unexpected pod IPs %v", ips)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package discovery encodes the list of memcached endpoints published to
+// clients, keeping its order stable across membership changes.
+package discovery
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+const (
+	// ServersKey holds the comma-separated "ip:port" list of the servers.
+	ServersKey = "servers"
+	// EndpointsKey holds the JSON encoding of the endpoints.
+	EndpointsKey = "endpoints.json"
+)
+
+// Endpoint is a ready memcached server.
+type Endpoint struct {
+	Pod     string `json:"pod"`
+	Address string `json:"address"`
+}
+
+type document struct {
+	Servers []Endpoint `json:"servers"`
+}
+
+// Order returns the ready endpoints in an order suited to client-side hashing:
+// endpoints already published keep their relative position, departed ones are
+// dropped and new ones are appended sorted by pod name. The order does not
+// depend on the order the pods are listed in, so it only changes with the
+// set of servers. Clients hashing keys by server name, like ketama, then
+// only remap the keys of the servers that changed; clients mapping keys by
+// position still remap the keys of every server after a departed one.
+func Order(published, ready []Endpoint) []Endpoint {
+	current := map[string]Endpoint{}
+	for _, ep := range ready {
+		current[ep.Pod] = ep
+	}
+
+	ordered := []Endpoint{}
+	seen := map[string]bool{}
+	for _, ep := range published {
+		if now, ok := current[ep.Pod]; ok && !seen[ep.Pod] {
+			ordered = append(ordered, now)
+			seen[ep.Pod] = true
+		}
+	}
+
+	var added []Endpoint
+	for _, ep := range ready {
+		if !seen[ep.Pod] {
+			added = append(added, ep)
+			seen[ep.Pod] = true
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].Pod < added[j].Pod })
+	return append(ordered, added...)
+}
+
+// Encode returns the ConfigMap data publishing the endpoints.
+func Encode(endpoints []Endpoint) (map[string]string, error) {
+	if endpoints == nil {
+		endpoints = []Endpoint{}
+	}
+	doc, err := json.Marshal(document{Servers: endpoints})
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		addrs = append(addrs, ep.Address)
+	}
+	return map[string]string{
+		ServersKey:   strings.Join(addrs, ","),
+		EndpointsKey: string(doc),
+	}, nil
+}
+
+// Decode returns the endpoints published in the ConfigMap data. Malformed
+// data decodes to no endpoints so that it is simply republished.
+func Decode(data map[string]string) []Endpoint {
+	var doc document
+	if err := json.Unmarshal([]byte(data[EndpointsKey]), &doc); err != nil {
+		return nil
+	}
+	return doc.Servers
+}
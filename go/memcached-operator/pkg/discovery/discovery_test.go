@@ -0,0 +1,100 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"reflect"
+	"testing"
+)
+
+func ep(pod, addr string) Endpoint {
+	return Endpoint{Pod: pod, Address: addr}
+}
+
+func TestOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		published []Endpoint
+		ready     []Endpoint
+		want      []Endpoint
+	}{
+		{
+			name:  "first publication is sorted by pod",
+			ready: []Endpoint{ep("c", "3"), ep("a", "1"), ep("b", "2")},
+			want:  []Endpoint{ep("a", "1"), ep("b", "2"), ep("c", "3")},
+		},
+		{
+			name:      "new pods are appended",
+			published: []Endpoint{ep("c", "3"), ep("a", "1")},
+			ready:     []Endpoint{ep("a", "1"), ep("d", "4"), ep("b", "2"), ep("c", "3")},
+			want:      []Endpoint{ep("c", "3"), ep("a", "1"), ep("b", "2"), ep("d", "4")},
+		},
+		{
+			name:      "departed pods are dropped without reordering",
+			published: []Endpoint{ep("c", "3"), ep("a", "1"), ep("b", "2")},
+			ready:     []Endpoint{ep("b", "2"), ep("c", "3")},
+			want:      []Endpoint{ep("c", "3"), ep("b", "2")},
+		},
+		{
+			name:      "address changes keep the position",
+			published: []Endpoint{ep("b", "2"), ep("a", "1")},
+			ready:     []Endpoint{ep("a", "10"), ep("b", "2")},
+			want:      []Endpoint{ep("b", "2"), ep("a", "10")},
+		},
+		{
+			name:      "no ready pods",
+			published: []Endpoint{ep("a", "1")},
+			want:      []Endpoint{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Order(tt.published, tt.ready)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	endpoints := []Endpoint{ep("b", "10.0.0.2:11211"), ep("a", "10.0.0.1:11211")}
+	data, err := Encode(endpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := data[ServersKey]; got != "10.0.0.2:11211,10.0.0.1:11211" {
+		t.Errorf("unexpected servers %q", got)
+	}
+	if got := Decode(data); !reflect.DeepEqual(got, endpoints) {
+		t.Errorf("round trip returned %v", got)
+	}
+
+	data, err = Encode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[ServersKey] != "" || data[EndpointsKey] != `{"servers":[]}` {
+		t.Errorf("unexpected encoding of no endpoints: %v", data)
+	}
+	if got := Decode(map[string]string{EndpointsKey: "{"}); got != nil {
+		t.Errorf("expected malformed data to decode to nothing, got %v", got)
+	}
+}
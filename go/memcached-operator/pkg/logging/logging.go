@@ -0,0 +1,258 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging builds the logger of the operator from command line flags:
+// its verbosity, overall and per logger name, its encoding, the level from
+// which stack traces are recorded and the sampling of repeated messages.
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	crzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// Encoders supported by Options.Encoder.
+const (
+	JSONEncoder    = "json"
+	ConsoleEncoder = "console"
+)
+
+// Options configures the logger.
+type Options struct {
+	// Verbosity is the highest V level logged. 0 logs informational
+	// messages, -1 only errors.
+	Verbosity int
+	// Verbosities overrides Verbosity for the loggers with a given name and
+	// their descendants, such as "controllers.Memcached".
+	Verbosities map[string]int
+	// Encoder is either JSONEncoder or ConsoleEncoder.
+	Encoder string
+	// StacktraceLevel is the level from which stack traces are recorded.
+	StacktraceLevel zapcore.Level
+	// Sampling drops repeated messages beyond the first 100 per second.
+	Sampling bool
+	// Output is where logs are written. Defaults to os.Stderr.
+	Output io.Writer
+}
+
+// NewOptions returns the options of a production logger.
+func NewOptions() Options {
+	return Options{
+		Encoder:         JSONEncoder,
+		StacktraceLevel: zapcore.ErrorLevel,
+		Sampling:        true,
+	}
+}
+
+// BindFlags binds the options to flags of fs.
+func (o *Options) BindFlags(fs *flag.FlagSet) {
+	fs.Var((*verbosityFlag)(&o.Verbosity), "log-level",
+		"The verbosity of the logs: 'error', 'info', 'debug' or a V level such as 2.")
+	fs.Var((*verbositiesFlag)(&o.Verbosities), "log-verbosity",
+		"Per logger verbosity overrides, such as 'controllers.Memcached=2,memcached-resource=error'.")
+	fs.StringVar(&o.Encoder, "log-encoder", o.Encoder, "The encoding of the logs: 'json' or 'console'.")
+	fs.Var((*levelFlag)(&o.StacktraceLevel), "log-stacktrace-level",
+		"The level from which stack traces are recorded: 'info', 'error' or 'panic'.")
+	fs.BoolVar(&o.Sampling, "log-sampling", o.Sampling,
+		"Whether to drop identical messages beyond the first 100 per second.")
+}
+
+// New returns a logger configured by o.
+func New(o Options) (logr.Logger, error) {
+	encCfg := zap.NewProductionEncoderConfig()
+	encCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	var enc zapcore.Encoder
+	switch o.Encoder {
+	case JSONEncoder, "":
+		enc = zapcore.NewJSONEncoder(encCfg)
+	case ConsoleEncoder:
+		enc = zapcore.NewConsoleEncoder(encCfg)
+	default:
+		return nil, fmt.Errorf("unknown log encoder %q", o.Encoder)
+	}
+	out := o.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	sink := zapcore.AddSync(out)
+
+	// The core logs everything some logger may log, the per name
+	// verbosities are enforced by verbosityLogger.
+	max := o.Verbosity
+	for _, v := range o.Verbosities {
+		if v > max {
+			max = v
+		}
+	}
+	level := zapcore.Level(-max)
+	if max < 0 {
+		level = zapcore.ErrorLevel
+	}
+	core := zapcore.NewCore(&crzap.KubeAwareEncoder{Encoder: enc}, sink, zap.NewAtomicLevelAt(level))
+	// The sampler of this zap version only supports levels from debug.
+	if o.Sampling && level >= zapcore.DebugLevel {
+		core = zapcore.NewSampler(core, time.Second, 100, 100)
+	}
+	z := zap.New(core, zap.AddStacktrace(o.StacktraceLevel), zap.ErrorOutput(sink))
+	return &verbosityLogger{Logger: zapr.NewLogger(z), opts: &o}, nil
+}
+
+// verbosityLogger filters the messages of a logger by the verbosity of its name.
+type verbosityLogger struct {
+	logr.Logger
+	name string
+	opts *Options
+}
+
+// verbosity returns the verbosity of the longest name overridden in
+// Verbosities that is the name of the logger or one of its ancestors.
+func (l *verbosityLogger) verbosity() int {
+	v, matched := l.opts.Verbosity, -1
+	for name, nv := range l.opts.Verbosities {
+		if len(name) > matched && (l.name == name || strings.HasPrefix(l.name, name+".")) {
+			v, matched = nv, len(name)
+		}
+	}
+	return v
+}
+
+func (l *verbosityLogger) Enabled() bool {
+	return l.verbosity() >= 0 && l.Logger.Enabled()
+}
+
+func (l *verbosityLogger) Info(msg string, keysAndValues ...interface{}) {
+	if l.verbosity() >= 0 {
+		l.Logger.Info(msg, keysAndValues...)
+	}
+}
+
+func (l *verbosityLogger) V(level int) logr.InfoLogger {
+	if level > l.verbosity() {
+		return disabled{}
+	}
+	return l.Logger.V(level)
+}
+
+func (l *verbosityLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	return &verbosityLogger{Logger: l.Logger.WithValues(keysAndValues...), name: l.name, opts: l.opts}
+}
+
+func (l *verbosityLogger) WithName(name string) logr.Logger {
+	full := name
+	if l.name != "" {
+		full = l.name + "." + name
+	}
+	return &verbosityLogger{Logger: l.Logger.WithName(name), name: full, opts: l.opts}
+}
+
+type disabled struct{}
+
+func (disabled) Enabled() bool                   { return false }
+func (disabled) Info(_ string, _ ...interface{}) {}
+
+// parseVerbosity parses 'error', 'info', 'debug' or a V level.
+func parseVerbosity(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "error":
+		return -1, nil
+	case "info":
+		return 0, nil
+	case "debug":
+		return 1, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid log level %q", s)
+	}
+	return v, nil
+}
+
+func formatVerbosity(v int) string {
+	switch v {
+	case -1:
+		return "error"
+	case 0:
+		return "info"
+	}
+	return strconv.Itoa(v)
+}
+
+type verbosityFlag int
+
+func (f *verbosityFlag) String() string { return formatVerbosity(int(*f)) }
+
+func (f *verbosityFlag) Set(s string) error {
+	v, err := parseVerbosity(s)
+	if err != nil {
+		return err
+	}
+	*f = verbosityFlag(v)
+	return nil
+}
+
+type verbositiesFlag map[string]int
+
+func (f *verbositiesFlag) String() string {
+	var pairs []string
+	for name, v := range *f {
+		pairs = append(pairs, name+"="+formatVerbosity(v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f *verbositiesFlag) Set(s string) error {
+	if *f == nil {
+		*f = map[string]int{}
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid log verbosity %q, expected name=level", pair)
+		}
+		v, err := parseVerbosity(kv[1])
+		if err != nil {
+			return err
+		}
+		(*f)[kv[0]] = v
+	}
+	return nil
+}
+
+type levelFlag zapcore.Level
+
+func (f *levelFlag) String() string { return zapcore.Level(*f).String() }
+
+func (f *levelFlag) Set(s string) error {
+	var level zapcore.Level
+	if err := level.Set(s); err != nil {
+		return err
+	}
+	*f = levelFlag(level)
+	return nil
+}
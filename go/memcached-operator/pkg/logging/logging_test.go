@@ -0,0 +1,121 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// lines decodes the JSON log lines written to buf.
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid JSON log line %q: %v", line, err)
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+func TestPerNameVerbosity(t *testing.T) {
+	buf := &bytes.Buffer{}
+	o := NewOptions()
+	o.Output = buf
+	o.Verbosities = map[string]int{"controllers.Memcached": 2, "noisy": -1}
+	log, err := New(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reconciler := log.WithName("controllers").WithName("Memcached").WithValues("memcached", "cache", "namespace", "default")
+	reconciler.V(1).Info("debug")
+	reconciler.V(3).Info("too verbose")
+	log.WithName("controllers").WithName("Other").V(1).Info("other debug")
+	log.WithName("setup").Info("starting")
+	noisy := log.WithName("noisy").WithName("child")
+	noisy.Info("chatty")
+	noisy.Error(errors.New("boom"), "failed")
+
+	got := lines(t, buf)
+	var msgs []string
+	for _, entry := range got {
+		msgs = append(msgs, entry["msg"].(string))
+	}
+	if want := []string{"debug", "starting", "failed"}; !reflect.DeepEqual(msgs, want) {
+		t.Fatalf("expected %v, got %v", want, msgs)
+	}
+	if got[0]["logger"] != "controllers.Memcached" || got[0]["memcached"] != "cache" || got[0]["namespace"] != "default" {
+		t.Errorf("expected the logger name and keys of the reconciler, got %v", got[0])
+	}
+	if _, ok := got[2]["stacktrace"]; !ok {
+		t.Errorf("expected a stack trace on errors, got %v", got[2])
+	}
+}
+
+func TestBindFlags(t *testing.T) {
+	o := NewOptions()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	o.BindFlags(fs)
+	err := fs.Parse([]string{
+		"--log-level=debug",
+		"--log-verbosity=controllers.Memcached=3,memcached-resource=error",
+		"--log-encoder=console",
+		"--log-stacktrace-level=panic",
+		"--log-sampling=false",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Options{
+		Verbosity:       1,
+		Verbosities:     map[string]int{"controllers.Memcached": 3, "memcached-resource": -1},
+		Encoder:         ConsoleEncoder,
+		StacktraceLevel: zapcore.PanicLevel,
+	}
+	if !reflect.DeepEqual(o, want) {
+		t.Errorf("expected %+v, got %+v", want, o)
+	}
+
+	for _, args := range [][]string{
+		{"--log-level=loud"},
+		{"--log-verbosity=controllers"},
+		{"--log-stacktrace-level=never"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(&bytes.Buffer{})
+		(&Options{}).BindFlags(fs)
+		if err := fs.Parse(args); err == nil {
+			t.Errorf("expected %v to be rejected", args)
+		}
+	}
+	if _, err := New(Options{Encoder: "xml"}); err == nil {
+		t.Error("expected an unknown encoder to be rejected")
+	}
+}
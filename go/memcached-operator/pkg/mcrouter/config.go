@@ -0,0 +1,103 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mcrouter generates mcrouter configurations routing to a pool of
+// memcached servers.
+package mcrouter
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// PoolName is the name of the single pool holding the memcached servers.
+const PoolName = "memcached"
+
+// Mode is the routing policy applied to the pool.
+type Mode string
+
+const (
+	// Sharded spreads the keys over the servers with consistent hashing.
+	Sharded Mode = "Sharded"
+	// Replicated writes every key to all servers and reads from any of them,
+	// failing over to the next server on errors.
+	Replicated Mode = "Replicated"
+)
+
+// Config is an mcrouter configuration file.
+type Config struct {
+	Pools map[string]Pool `json:"pools"`
+	Route interface{}     `json:"route"`
+}
+
+// Pool is a named list of memcached servers.
+type Pool struct {
+	Servers []string `json:"servers"`
+}
+
+// OperationSelectorRoute routes each memcached operation with its own policy.
+type OperationSelectorRoute struct {
+	Type              string            `json:"type"`
+	OperationPolicies map[string]string `json:"operation_policies"`
+	DefaultPolicy     string            `json:"default_policy"`
+}
+
+// Generate returns the mcrouter configuration for servers, given as
+// "host:port" addresses. The servers are sorted so that the output only
+// changes when the membership does. An empty server list yields a
+// configuration that answers every request with a miss.
+func Generate(mode Mode, servers []string) (*Config, error) {
+	sorted := append([]string(nil), servers...)
+	sort.Strings(sorted)
+
+	cfg := &Config{Pools: map[string]Pool{}}
+	if len(sorted) == 0 {
+		cfg.Route = "NullRoute"
+		return cfg, nil
+	}
+	cfg.Pools[PoolName] = Pool{Servers: sorted}
+
+	pool := "Pool|" + PoolName
+	switch mode {
+	case Sharded, "":
+		cfg.Route = "PoolRoute|" + PoolName
+	case Replicated:
+		cfg.Route = OperationSelectorRoute{
+			Type: "OperationSelectorRoute",
+			OperationPolicies: map[string]string{
+				"get":    "LatestRoute|" + pool,
+				"gets":   "LatestRoute|" + pool,
+				"add":    "AllSyncRoute|" + pool,
+				"set":    "AllSyncRoute|" + pool,
+				"delete": "AllSyncRoute|" + pool,
+			},
+			DefaultPolicy: "AllSyncRoute|" + pool,
+		}
+	default:
+		return nil, fmt.Errorf("unknown mcrouter mode %q", mode)
+	}
+	return cfg, nil
+}
+
+// Marshal returns the JSON encoding of the configuration generated by Generate.
+func Marshal(mode Mode, servers []string) ([]byte, error) {
+	cfg, err := Generate(mode, servers)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(cfg, "", "  ")
+}
@@ -0,0 +1,102 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mcrouter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    Mode
+		servers []string
+		want    string
+	}{
+		{
+			name: "no servers",
+			mode: Sharded,
+			want: `{"pools":{},"route":"NullRoute"}`,
+		},
+		{
+			name:    "sharded sorts servers",
+			mode:    Sharded,
+			servers: []string{"10.0.0.2:11211", "10.0.0.1:11211"},
+			want:    `{"pools":{"memcached":{"servers":["10.0.0.1:11211","10.0.0.2:11211"]}},"route":"PoolRoute|memcached"}`,
+		},
+		{
+			name:    "empty mode defaults to sharded",
+			servers: []string{"10.0.0.1:11211"},
+			want:    `{"pools":{"memcached":{"servers":["10.0.0.1:11211"]}},"route":"PoolRoute|memcached"}`,
+		},
+		{
+			name:    "replicated",
+			mode:    Replicated,
+			servers: []string{"10.0.0.1:11211", "10.0.0.2:11211"},
+			want: `{"pools":{"memcached":{"servers":["10.0.0.1:11211","10.0.0.2:11211"]}},"route":{
+				"type":"OperationSelectorRoute",
+				"operation_policies":{
+					"add":"AllSyncRoute|Pool|memcached",
+					"delete":"AllSyncRoute|Pool|memcached",
+					"get":"LatestRoute|Pool|memcached",
+					"gets":"LatestRoute|Pool|memcached",
+					"set":"AllSyncRoute|Pool|memcached"
+				},
+				"default_policy":"AllSyncRoute|Pool|memcached"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Marshal(tt.mode, tt.servers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotJSON, wantJSON interface{}
+			if err := json.Unmarshal(got, &gotJSON); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &wantJSON); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotJSON, wantJSON) {
+				t.Errorf("unexpected config:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalIsStable(t *testing.T) {
+	a, err := Marshal(Replicated, []string{"b:1", "a:1", "c:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Marshal(Replicated, []string{"c:1", "b:1", "a:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) != string(b) {
+		t.Errorf("expected the same config regardless of server order:\n%s\n%s", a, b)
+	}
+}
+
+func TestGenerateRejectsUnknownMode(t *testing.T) {
+	if _, err := Generate("Broadcast", []string{"a:1"}); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
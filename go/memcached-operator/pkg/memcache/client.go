@@ -0,0 +1,253 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package memcache implements the small subset of the memcached text protocol
+// the operator needs to talk to the pods it manages.
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPort is the port memcached listens on in the pods created by the operator.
+const DefaultPort = 11211
+
+// ErrCacheMiss is returned by Get when the key is not stored on the server.
+var ErrCacheMiss = errors.New("memcache: cache miss")
+
+// Item is a value stored in memcached.
+type Item struct {
+	Key   string
+	Value []byte
+	Flags uint32
+	// Expiration is either a relative number of seconds or an absolute unix
+	// timestamp, following the memcached conventions. Zero means no expiry.
+	Expiration int64
+}
+
+// KeyInfo describes a key returned by "lru_crawler metadump".
+type KeyInfo struct {
+	Key string
+	// Expiration is the absolute unix time the key expires at, or -1 if it never expires.
+	Expiration int64
+	Size       int
+}
+
+// Client is a connection to a single memcached server. It is not safe for
+// concurrent use.
+type Client struct {
+	conn    net.Conn
+	rw      *bufio.ReadWriter
+	timeout time.Duration
+}
+
+// Dial connects to the memcached server at addr. The timeout bounds the
+// connection attempt and every subsequent request.
+func Dial(addr string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		conn:    conn,
+		rw:      bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
+		timeout: timeout,
+	}, nil
+}
+
+// Close closes the underlying connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Get fetches the item stored under key.
+func (c *Client) Get(key string) (*Item, error) {
+	if err := c.send("get %s\r\n", key); err != nil {
+		return nil, err
+	}
+	var item *Item
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "END" {
+			break
+		}
+		// VALUE <key> <flags> <bytes>
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != "VALUE" {
+			return nil, fmt.Errorf("memcache: unexpected get response %q", line)
+		}
+		flags, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("memcache: invalid flags in %q", line)
+		}
+		size, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("memcache: invalid size in %q", line)
+		}
+		value := make([]byte, size+2)
+		if _, err := io.ReadFull(c.rw, value); err != nil {
+			return nil, err
+		}
+		if string(value[size:]) != "\r\n" {
+			return nil, fmt.Errorf("memcache: corrupt value for key %q", fields[1])
+		}
+		item = &Item{Key: fields[1], Value: value[:size], Flags: uint32(flags)}
+	}
+	if item == nil {
+		return nil, ErrCacheMiss
+	}
+	return item, nil
+}
+
+// Set stores item on the server unconditionally.
+func (c *Client) Set(item *Item) error {
+	if err := c.send("set %s %d %d %d\r\n%s\r\n",
+		item.Key, item.Flags, item.Expiration, len(item.Value), item.Value); err != nil {
+		return err
+	}
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if line != "STORED" {
+		return fmt.Errorf("memcache: set %q: %s", item.Key, line)
+	}
+	return nil
+}
+
+// MetaDump lists the keys held by the server using "lru_crawler metadump all".
+// At most max keys are returned; a max of zero or less returns every key.
+func (c *Client) MetaDump(max int) ([]KeyInfo, error) {
+	if err := c.send("lru_crawler metadump all\r\n"); err != nil {
+		return nil, err
+	}
+	var keys []KeyInfo
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "END" {
+			return keys, nil
+		}
+		if strings.HasPrefix(line, "ERROR") || strings.HasPrefix(line, "BUSY") {
+			return nil, fmt.Errorf("memcache: metadump: %s", line)
+		}
+		info, err := parseMetaDumpLine(line)
+		if err != nil {
+			return nil, err
+		}
+		// Keep draining the response so the connection stays usable.
+		if max <= 0 || len(keys) < max {
+			keys = append(keys, info)
+		}
+	}
+}
+
+// Stats returns the general-purpose statistics reported by "stats".
+func (c *Client) Stats() (map[string]string, error) {
+	if err := c.send("stats\r\n"); err != nil {
+		return nil, err
+	}
+	stats := map[string]string{}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "END" {
+			return stats, nil
+		}
+		// STAT <name> <value>
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 || fields[0] != "STAT" {
+			return nil, fmt.Errorf("memcache: unexpected stats response %q", line)
+		}
+		stats[fields[1]] = fields[2]
+	}
+}
+
+// Version returns the server version.
+func (c *Client) Version() (string, error) {
+	if err := c.send("version\r\n"); err != nil {
+		return "", err
+	}
+	line, err := c.readLine()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "VERSION ") {
+		return "", fmt.Errorf("memcache: unexpected version response %q", line)
+	}
+	return strings.TrimPrefix(line, "VERSION "), nil
+}
+
+func (c *Client) send(format string, args ...interface{}) error {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.rw, format, args...); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+func (c *Client) readLine() (string, error) {
+	line, err := c.rw.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// parseMetaDumpLine parses lines such as
+// "key=foo exp=-1 la=1600000000 cas=2 fetch=no cls=1 size=63".
+func parseMetaDumpLine(line string) (KeyInfo, error) {
+	info := KeyInfo{Expiration: -1}
+	for _, field := range strings.Fields(line) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		var err error
+		switch kv[0] {
+		case "key":
+			info.Key, err = url.QueryUnescape(kv[1])
+		case "exp":
+			info.Expiration, err = strconv.ParseInt(kv[1], 10, 64)
+		case "size":
+			info.Size, err = strconv.Atoi(kv[1])
+		}
+		if err != nil {
+			return KeyInfo{}, fmt.Errorf("memcache: invalid metadump line %q: %v", line, err)
+		}
+	}
+	if info.Key == "" {
+		return KeyInfo{}, fmt.Errorf("memcache: invalid metadump line %q", line)
+	}
+	return info, nil
+}
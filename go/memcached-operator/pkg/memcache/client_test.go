@@ -0,0 +1,251 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memcache
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve accepts a single connection on a local listener, answers its first
+// request with reply and closes it. It returns the address to dial and a
+// channel receiving the request.
+func serve(t *testing.T, reply string) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		r := bufio.NewReader(conn)
+		request, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		// The data block of a storage command.
+		if strings.HasPrefix(request, "set ") {
+			data, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			request += data
+		}
+		requests <- request
+		conn.Write([]byte(reply))
+	}()
+	return l.Addr().String(), requests
+}
+
+func dial(t *testing.T, addr string) *Client {
+	c, err := Dial(addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  *Item
+		err   string
+	}{
+		{name: "hit", reply: "VALUE k 7 2\r\nv1\r\nEND\r\n", want: &Item{Key: "k", Value: []byte("v1"), Flags: 7}},
+		{name: "binary value", reply: "VALUE k 0 4\r\na\r\nb\r\nEND\r\n", want: &Item{Key: "k", Value: []byte("a\r\nb")}},
+		{name: "miss", reply: "END\r\n", err: ErrCacheMiss.Error()},
+		{name: "error", reply: "ERROR\r\n", err: `unexpected get response "ERROR"`},
+		{name: "server error", reply: "SERVER_ERROR out of memory\r\n", err: `unexpected get response "SERVER_ERROR out of memory"`},
+		{name: "invalid flags", reply: "VALUE k x 2\r\nv1\r\nEND\r\n", err: "invalid flags"},
+		{name: "corrupt value", reply: "VALUE k 0 2\r\nv1XXEND\r\n", err: `corrupt value for key "k"`},
+		{name: "truncated", reply: "VALUE k 0 5\r\nv1\r\n", err: "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, requests := serve(t, tt.reply)
+			c := dial(t, addr)
+			defer c.Close()
+			got, err := c.Get("k")
+			if request := <-requests; request != "get k\r\n" {
+				t.Errorf("unexpected request %q", request)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		reply string
+		err   string
+	}{
+		{reply: "STORED\r\n"},
+		{reply: "NOT_STORED\r\n", err: `set "k": NOT_STORED`},
+		{reply: "SERVER_ERROR object too large for cache\r\n", err: `set "k": SERVER_ERROR object too large for cache`},
+	}
+	for _, tt := range tests {
+		addr, requests := serve(t, tt.reply)
+		c := dial(t, addr)
+		err := c.Set(&Item{Key: "k", Value: []byte("v1"), Flags: 7, Expiration: 60})
+		c.Close()
+		if request := <-requests; request != "set k 7 60 2\r\nv1\r\n" {
+			t.Errorf("unexpected request %q", request)
+		}
+		if tt.err == "" && err != nil {
+			t.Errorf("%q: unexpected error %v", tt.reply, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%q: expected an error containing %q, got %v", tt.reply, tt.err, err)
+		}
+	}
+}
+
+func TestMetaDump(t *testing.T) {
+	const dump = "key=plain exp=-1 la=1600000000 cas=2 fetch=no cls=1 size=63\r\n" +
+		"key=with%20space%2Bplus exp=1600000600 la=1600000000 cas=3 fetch=yes cls=1 size=70\r\n" +
+		"END\r\n"
+	tests := []struct {
+		name  string
+		reply string
+		max   int
+		want  []KeyInfo
+		err   string
+	}{
+		{
+			name:  "every key",
+			reply: dump,
+			want: []KeyInfo{
+				{Key: "plain", Expiration: -1, Size: 63},
+				{Key: "with space+plus", Expiration: 1600000600, Size: 70},
+			},
+		},
+		{
+			name:  "limited",
+			reply: dump,
+			max:   1,
+			want:  []KeyInfo{{Key: "plain", Expiration: -1, Size: 63}},
+		},
+		{name: "empty", reply: "END\r\n"},
+		{name: "unsupported", reply: "ERROR\r\n", err: "metadump: ERROR"},
+		{name: "busy", reply: "BUSY currently processing crawler request\r\n", err: "metadump: BUSY"},
+		{name: "missing key", reply: "exp=-1 size=3\r\nEND\r\n", err: "invalid metadump line"},
+		{name: "invalid escape", reply: "key=a%zz exp=-1 size=3\r\nEND\r\n", err: "invalid metadump line"},
+		{name: "invalid expiry", reply: "key=a exp=never size=3\r\nEND\r\n", err: "invalid metadump line"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, requests := serve(t, tt.reply)
+			c := dial(t, addr)
+			defer c.Close()
+			got, err := c.MetaDump(tt.max)
+			if request := <-requests; request != "lru_crawler metadump all\r\n" {
+				t.Errorf("unexpected request %q", request)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  map[string]string
+		err   string
+	}{
+		{
+			name:  "stats",
+			reply: "STAT pid 1\r\nSTAT version 1.6.9\r\nSTAT rusage_user 0.1 extra\r\nEND\r\n",
+			want:  map[string]string{"pid": "1", "version": "1.6.9", "rusage_user": "0.1 extra"},
+		},
+		{name: "error", reply: "ERROR\r\n", err: `unexpected stats response "ERROR"`},
+		{name: "malformed", reply: "STAT pid\r\nEND\r\n", err: `unexpected stats response "STAT pid"`},
+		{name: "truncated", reply: "STAT pid 1\r\n", err: "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, requests := serve(t, tt.reply)
+			c := dial(t, addr)
+			defer c.Close()
+			got, err := c.Stats()
+			if request := <-requests; request != "stats\r\n" {
+				t.Errorf("unexpected request %q", request)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	addr, _ := serve(t, "VERSION 1.6.9\r\n")
+	c := dial(t, addr)
+	defer c.Close()
+	if got, err := c.Version(); err != nil || got != "1.6.9" {
+		t.Errorf("expected version 1.6.9, got %q, %v", got, err)
+	}
+
+	addr, _ = serve(t, "ERROR\r\n")
+	c = dial(t, addr)
+	defer c.Close()
+	if _, err := c.Version(); err == nil {
+		t.Error("expected an error for an unexpected response")
+	}
+}
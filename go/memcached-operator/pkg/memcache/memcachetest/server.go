@@ -0,0 +1,472 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package memcachetest provides an in-process memcached server for tests of
+// the code talking to memcached pods. It speaks the subset of the text and
+// meta protocols used by the operator and can inject latency, dropped
+// connections and garbled replies.
+package memcachetest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/memcached-operator/pkg/memcache"
+)
+
+// Version is the version reported by the server.
+const Version = "1.6.9"
+
+// limitMaxBytes is the memory limit reported in the stats, the default of
+// memcached.
+const limitMaxBytes = 64 << 20
+
+// Faults are the failures a Server injects in its replies.
+type Faults struct {
+	// Latency delays every reply.
+	Latency time.Duration
+	// Drop closes the connection instead of replying.
+	Drop bool
+	// Garble replaces the reply with a line memcached never sends.
+	Garble bool
+	// Commands restricts the faults to the commands of these names, such as
+	// "get" or "mg". The faults apply to every command when empty.
+	Commands []string
+}
+
+func (f Faults) apply(command string) bool {
+	if len(f.Commands) == 0 {
+		return true
+	}
+	for _, c := range f.Commands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+// Server is an in-process memcached listening on a local TCP port. Items are
+// kept as set, their expiration included: they never expire nor get evicted.
+type Server struct {
+	// Addr is the address the server listens on, as host:port.
+	Addr string
+
+	ln    net.Listener
+	mu    sync.Mutex
+	items map[string]memcache.Item
+	stats map[string]string
+	// faults are the failures injected in the replies.
+	faults Faults
+	// conns are the open connections.
+	conns    map[net.Conn]bool
+	hits     uint64
+	misses   uint64
+	commands map[string]int
+}
+
+// NewServer starts a server holding the given items. It panics if it cannot
+// listen, like httptest.NewServer. Callers should Close it when done.
+func NewServer(items ...memcache.Item) *Server {
+	s, err := Listen("127.0.0.1:0", items...)
+	if err != nil {
+		panic(fmt.Sprintf("memcachetest: failed to listen: %v", err))
+	}
+	return s
+}
+
+// Listen starts a server holding the given items on addr, such as the
+// address of a simulated pod. Unlike NewServer it returns an error when the
+// address is not available.
+func Listen(addr string, items ...memcache.Item) (*Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:     ln.Addr().String(),
+		ln:       ln,
+		items:    map[string]memcache.Item{},
+		stats:    map[string]string{},
+		conns:    map[net.Conn]bool{},
+		commands: map[string]int{},
+	}
+	for _, item := range items {
+		s.Set(item)
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Close stops the server and closes its connections. Connecting to Addr
+// fails afterwards, as with a pod that went away.
+func (s *Server) Close() {
+	s.ln.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Set stores item, replacing the item of the same key.
+func (s *Server) Set(item memcache.Item) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item.Value = append([]byte(nil), item.Value...)
+	s.items[item.Key] = item
+}
+
+// Get returns the item stored under key.
+func (s *Server) Get(key string) (memcache.Item, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	return item, ok
+}
+
+// Delete removes the item stored under key, as an eviction would.
+func (s *Server) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, key)
+}
+
+// Keys returns the stored keys, sorted.
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SetStat overrides a statistic reported by the stats command, such as
+// "evictions", which the server does not compute.
+func (s *Server) SetStat(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats[name] = value
+}
+
+// SetFaults sets the failures injected in the replies from now on. The zero
+// Faults restores normal replies.
+func (s *Server) SetFaults(f Faults) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = f
+}
+
+// Commands returns how many commands of the given name the server received.
+func (s *Server) Commands(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands[name]
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var reply strings.Builder
+		if !s.execute(fields, r, &reply) {
+			return
+		}
+
+		s.mu.Lock()
+		s.commands[fields[0]]++
+		faults := s.faults
+		s.mu.Unlock()
+		if faults.apply(fields[0]) {
+			time.Sleep(faults.Latency)
+			if faults.Drop {
+				return
+			}
+			if faults.Garble {
+				reply.Reset()
+				reply.WriteString("GARBLED \x00\xff\r\n")
+			}
+		}
+		if _, err := io.WriteString(conn, reply.String()); err != nil {
+			return
+		}
+	}
+}
+
+// execute runs the command of the given fields, reading its data from r, and
+// writes the reply to w. It returns false when the connection must be closed.
+func (s *Server) execute(fields []string, r *bufio.Reader, w io.Writer) bool {
+	switch fields[0] {
+	case "get", "gets":
+		for _, key := range fields[1:] {
+			if item, ok := s.lookup(key); ok {
+				fmt.Fprintf(w, "VALUE %s %d %d\r\n%s\r\n", key, item.Flags, len(item.Value), item.Value)
+			}
+		}
+		fmt.Fprint(w, "END\r\n")
+	case "set":
+		// set <key> <flags> <exptime> <bytes>
+		if len(fields) < 5 {
+			fmt.Fprint(w, "ERROR\r\n")
+			return true
+		}
+		flags, err1 := strconv.ParseUint(fields[2], 10, 32)
+		exp, err2 := strconv.ParseInt(fields[3], 10, 64)
+		size, err3 := strconv.Atoi(fields[4])
+		if err1 != nil || err2 != nil || err3 != nil || size < 0 {
+			fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+			return true
+		}
+		value, ok := readData(r, size)
+		if !ok {
+			return false
+		}
+		s.Set(memcache.Item{Key: fields[1], Value: value, Flags: uint32(flags), Expiration: exp})
+		fmt.Fprint(w, "STORED\r\n")
+	case "delete":
+		if len(fields) < 2 {
+			fmt.Fprint(w, "ERROR\r\n")
+		} else if s.remove(fields[1]) {
+			fmt.Fprint(w, "DELETED\r\n")
+		} else {
+			fmt.Fprint(w, "NOT_FOUND\r\n")
+		}
+	case "stats":
+		s.writeStats(w)
+	case "version":
+		fmt.Fprintf(w, "VERSION %s\r\n", Version)
+	case "lru_crawler":
+		if len(fields) < 2 || fields[1] != "metadump" {
+			fmt.Fprint(w, "ERROR\r\n")
+			return true
+		}
+		s.writeMetaDump(w)
+	case "mg":
+		s.metaGet(fields, w)
+	case "ms":
+		return s.metaSet(fields, r, w)
+	case "md":
+		if len(fields) < 2 {
+			fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+		} else if s.remove(fields[1]) {
+			fmt.Fprint(w, "HD\r\n")
+		} else {
+			fmt.Fprint(w, "NF\r\n")
+		}
+	case "mn":
+		fmt.Fprint(w, "MN\r\n")
+	default:
+		fmt.Fprint(w, "ERROR\r\n")
+	}
+	return true
+}
+
+// lookup returns the item stored under key, counting the hit or miss.
+func (s *Server) lookup(key string) (memcache.Item, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	if ok {
+		s.hits++
+	} else {
+		s.misses++
+	}
+	return item, ok
+}
+
+func (s *Server) remove(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.items[key]
+	delete(s.items, key)
+	return ok
+}
+
+// readData reads a data block of size bytes followed by \r\n.
+func readData(r *bufio.Reader, size int) ([]byte, bool) {
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, false
+	}
+	return data[:size], string(data[size:]) == "\r\n"
+}
+
+func (s *Server) writeStats(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var bytes int
+	for _, item := range s.items {
+		bytes += len(item.Key) + len(item.Value)
+	}
+	stats := map[string]string{
+		"version":          Version,
+		"curr_connections": strconv.Itoa(len(s.conns)),
+		"curr_items":       strconv.Itoa(len(s.items)),
+		"get_hits":         strconv.FormatUint(s.hits, 10),
+		"get_misses":       strconv.FormatUint(s.misses, 10),
+		"evictions":        "0",
+		"bytes":            strconv.Itoa(bytes),
+		"limit_maxbytes":   strconv.Itoa(limitMaxBytes),
+	}
+	for name, value := range s.stats {
+		stats[name] = value
+	}
+	var names []string
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "STAT %s %s\r\n", name, stats[name])
+	}
+	fmt.Fprint(w, "END\r\n")
+}
+
+func (s *Server) writeMetaDump(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		item := s.items[key]
+		exp := item.Expiration
+		if exp == 0 {
+			exp = -1
+		}
+		fmt.Fprintf(w, "key=%s exp=%d la=0 cas=1 fetch=no cls=1 size=%d\r\n", url.QueryEscape(key), exp, len(item.Value))
+	}
+	fmt.Fprint(w, "END\r\n")
+}
+
+// metaGet implements "mg <key> <flags>*" with the v (value), f (client
+// flags), t (time to live), k (key) and s (size) flags.
+func (s *Server) metaGet(fields []string, w io.Writer) {
+	if len(fields) < 2 {
+		fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+	item, ok := s.lookup(fields[1])
+	if !ok {
+		fmt.Fprint(w, "EN\r\n")
+		return
+	}
+	var value bool
+	var ret []string
+	for _, flag := range fields[2:] {
+		switch flag[0] {
+		case 'v':
+			value = true
+		case 'f':
+			ret = append(ret, fmt.Sprintf("f%d", item.Flags))
+		case 't':
+			ttl := item.Expiration
+			if ttl == 0 {
+				ttl = -1
+			}
+			ret = append(ret, fmt.Sprintf("t%d", ttl))
+		case 'k':
+			ret = append(ret, "k"+item.Key)
+		case 's':
+			ret = append(ret, fmt.Sprintf("s%d", len(item.Value)))
+		}
+	}
+	suffix := ""
+	if len(ret) > 0 {
+		suffix = " " + strings.Join(ret, " ")
+	}
+	if value {
+		fmt.Fprintf(w, "VA %d%s\r\n%s\r\n", len(item.Value), suffix, item.Value)
+		return
+	}
+	fmt.Fprintf(w, "HD%s\r\n", suffix)
+}
+
+// metaSet implements "ms <key> <datalen> <flags>*" with the F (client flags)
+// and T (time to live) flags.
+func (s *Server) metaSet(fields []string, r *bufio.Reader, w io.Writer) bool {
+	if len(fields) < 3 {
+		fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+		return true
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil || size < 0 {
+		fmt.Fprint(w, "CLIENT_ERROR bad data chunk\r\n")
+		return true
+	}
+	value, ok := readData(r, size)
+	if !ok {
+		return false
+	}
+	item := memcache.Item{Key: fields[1], Value: value}
+	for _, flag := range fields[3:] {
+		var err error
+		switch flag[0] {
+		case 'F':
+			var flags uint64
+			flags, err = strconv.ParseUint(flag[1:], 10, 32)
+			item.Flags = uint32(flags)
+		case 'T':
+			item.Expiration, err = strconv.ParseInt(flag[1:], 10, 64)
+		}
+		if err != nil {
+			fmt.Fprint(w, "CLIENT_ERROR bad token in command line format\r\n")
+			return true
+		}
+	}
+	s.Set(item)
+	fmt.Fprint(w, "HD\r\n")
+	return true
+}
@@ -0,0 +1,204 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memcachetest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/memcached-operator/pkg/memcache"
+)
+
+func dial(t *testing.T, s *Server) *memcache.Client {
+	c, err := memcache.Dial(s.Addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// roundTrip sends a raw request and returns the reply lines up to and
+// including the given number of lines.
+func roundTrip(t *testing.T, s *Server, request string, lines int) []string {
+	conn, err := net.DialTimeout("tcp", s.Addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := fmt.Fprint(conn, request); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	var reply []string
+	for i := 0; i < lines; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%q: %v after %q", request, err, reply)
+		}
+		reply = append(reply, strings.TrimRight(line, "\r\n"))
+	}
+	return reply
+}
+
+func TestTextProtocol(t *testing.T) {
+	s := NewServer(memcache.Item{Key: "a", Value: []byte("1"), Flags: 3})
+	defer s.Close()
+	c := dial(t, s)
+	defer c.Close()
+
+	item, err := c.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(item.Value) != "1" || item.Flags != 3 {
+		t.Errorf("unexpected item %+v", item)
+	}
+	if _, err := c.Get("missing"); err != memcache.ErrCacheMiss {
+		t.Errorf("expected a cache miss, got %v", err)
+	}
+	if err := c.Set(&memcache.Item{Key: "b", Value: []byte("two\r\nlines"), Expiration: 1600000000}); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := s.Get("b"); !ok || string(got.Value) != "two\r\nlines" || got.Expiration != 1600000000 {
+		t.Errorf("unexpected stored item %+v", got)
+	}
+
+	keys, err := c.MetaDump(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []memcache.KeyInfo{{Key: "a", Expiration: -1, Size: 1}, {Key: "b", Expiration: 1600000000, Size: 10}}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("expected metadump %+v, got %+v", want, keys)
+	}
+
+	version, err := c.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != Version {
+		t.Errorf("expected version %s, got %s", Version, version)
+	}
+
+	s.SetStat("evictions", "7")
+	raw, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := memcache.ParseStats(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.GetHits != 1 || stats.GetMisses != 1 || stats.Evictions != 7 || stats.CurrConnections != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if got := roundTrip(t, s, "delete a\r\n", 1); got[0] != "DELETED" {
+		t.Errorf("expected DELETED, got %q", got)
+	}
+	if got := roundTrip(t, s, "delete a\r\n", 1); got[0] != "NOT_FOUND" {
+		t.Errorf("expected NOT_FOUND, got %q", got)
+	}
+	if got := s.Keys(); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("unexpected keys %v", got)
+	}
+	if got := s.Commands("get"); got != 2 {
+		t.Errorf("expected 2 get commands, got %d", got)
+	}
+}
+
+func TestMetaProtocol(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	for _, tt := range []struct {
+		request string
+		want    []string
+	}{
+		{"ms k 5 F9 T60\r\nhello\r\n", []string{"HD"}},
+		{"mg k v f t\r\n", []string{"VA 5 f9 t60", "hello"}},
+		{"mg k s k\r\n", []string{"HD s5 kk"}},
+		{"mg missing v\r\n", []string{"EN"}},
+		{"md k\r\n", []string{"HD"}},
+		{"md k\r\n", []string{"NF"}},
+		{"mn\r\n", []string{"MN"}},
+		{"bogus\r\n", []string{"ERROR"}},
+	} {
+		if got := roundTrip(t, s, tt.request, len(tt.want)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: expected %q, got %q", tt.request, tt.want, got)
+		}
+	}
+}
+
+func TestFaults(t *testing.T) {
+	s := NewServer(memcache.Item{Key: "a", Value: []byte("1")})
+	defer s.Close()
+
+	s.SetFaults(Faults{Garble: true, Commands: []string{"stats"}})
+	c := dial(t, s)
+	if _, err := c.Get("a"); err != nil {
+		t.Errorf("expected other commands to be unaffected, got %v", err)
+	}
+	if _, err := c.Stats(); err == nil {
+		t.Error("expected an error on a garbled reply")
+	}
+	c.Close()
+
+	s.SetFaults(Faults{Drop: true})
+	c = dial(t, s)
+	if _, err := c.Version(); err == nil {
+		t.Error("expected an error on a dropped connection")
+	}
+	c.Close()
+
+	s.SetFaults(Faults{Latency: 200 * time.Millisecond})
+	c, err := memcache.Dial(s.Addr, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Version(); err == nil {
+		t.Error("expected a timeout on a slow reply")
+	}
+	c.Close()
+
+	s.SetFaults(Faults{})
+	c = dial(t, s)
+	defer c.Close()
+	if _, err := c.Version(); err != nil {
+		t.Errorf("expected normal replies once the faults are cleared, got %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	s := NewServer()
+	c := dial(t, s)
+	defer c.Close()
+	s.Close()
+
+	if _, err := c.Version(); err == nil {
+		t.Error("expected open connections to be closed")
+	}
+	if _, err := memcache.Dial(s.Addr, time.Second); err == nil {
+		t.Error("expected new connections to be refused")
+	}
+}
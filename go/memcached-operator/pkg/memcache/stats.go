@@ -0,0 +1,76 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memcache
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ServerStats are the counters and gauges of "stats" the operator acts upon.
+type ServerStats struct {
+	// GetHits and GetMisses are counters since the server started.
+	GetHits   uint64
+	GetMisses uint64
+	// Evictions is a counter of items evicted to make room for new ones.
+	Evictions uint64
+	// Bytes is the memory currently used to store items.
+	Bytes uint64
+	// LimitMaxBytes is the memory the server may use, as set with -m.
+	LimitMaxBytes uint64
+	// CurrConnections is the number of open connections.
+	CurrConnections uint64
+}
+
+// ParseStats extracts ServerStats from the raw output of Client.Stats.
+func ParseStats(raw map[string]string) (ServerStats, error) {
+	var s ServerStats
+	for name, dst := range map[string]*uint64{
+		"get_hits":         &s.GetHits,
+		"get_misses":       &s.GetMisses,
+		"evictions":        &s.Evictions,
+		"bytes":            &s.Bytes,
+		"limit_maxbytes":   &s.LimitMaxBytes,
+		"curr_connections": &s.CurrConnections,
+	} {
+		value, ok := raw[name]
+		if !ok {
+			return ServerStats{}, fmt.Errorf("memcache: stat %q missing", name)
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return ServerStats{}, fmt.Errorf("memcache: invalid stat %s=%q", name, value)
+		}
+		*dst = n
+	}
+	return s, nil
+}
+
+// FetchStats connects to addr and returns its parsed statistics.
+func FetchStats(addr string, timeout time.Duration) (ServerStats, error) {
+	c, err := Dial(addr, timeout)
+	if err != nil {
+		return ServerStats{}, err
+	}
+	defer c.Close()
+	raw, err := c.Stats()
+	if err != nil {
+		return ServerStats{}, err
+	}
+	return ParseStats(raw)
+}
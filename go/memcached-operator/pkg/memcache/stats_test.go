@@ -0,0 +1,87 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memcache
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawStats returns the stats the operator reads, as reported by a server.
+func rawStats() map[string]string {
+	return map[string]string{
+		"pid":              "1",
+		"get_hits":         "90",
+		"get_misses":       "10",
+		"evictions":        "3",
+		"bytes":            "1048576",
+		"limit_maxbytes":   "67108864",
+		"curr_connections": "12",
+	}
+}
+
+func TestParseStats(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(map[string]string)
+		err    string
+	}{
+		{name: "complete", change: func(map[string]string) {}},
+		{name: "missing", change: func(raw map[string]string) { delete(raw, "evictions") }, err: `stat "evictions" missing`},
+		{name: "invalid", change: func(raw map[string]string) { raw["bytes"] = "-1" }, err: `invalid stat bytes="-1"`},
+	}
+	for _, tt := range tests {
+		raw := rawStats()
+		tt.change(raw)
+		got, err := ParseStats(raw)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		want := ServerStats{GetHits: 90, GetMisses: 10, Evictions: 3, Bytes: 1 << 20, LimitMaxBytes: 64 << 20, CurrConnections: 12}
+		if got != want {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, want, got)
+		}
+	}
+}
+
+func TestFetchStats(t *testing.T) {
+	var reply strings.Builder
+	for name, value := range rawStats() {
+		reply.WriteString("STAT " + name + " " + value + "\r\n")
+	}
+	reply.WriteString("END\r\n")
+	addr, _ := serve(t, reply.String())
+	got, err := FetchStats(addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Evictions != 3 || got.LimitMaxBytes != 64<<20 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+
+	addr, _ = serve(t, "ERROR\r\n")
+	if _, err := FetchStats(addr, time.Second); err == nil {
+		t.Error("expected an error when the server does not support stats")
+	}
+}
@@ -0,0 +1,134 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package options converts the extra memcached options of a ConfigMap to
+// command-line arguments. Each key of the ConfigMap names an option of an
+// allow-list, which leaves out the options the operator sets from the spec
+// and those that would break the pods, such as the port or the user.
+package options
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// kind is the type of value an option takes.
+type kind int
+
+const (
+	// flag options take "true" or "false" and are only passed when true.
+	flag kind = iota
+	// integer options take a positive integer.
+	integer
+	// size options take a positive number of bytes with an optional k or m
+	// suffix.
+	size
+	// factor options take a number greater than 1.
+	factor
+)
+
+type option struct {
+	arg  string
+	kind kind
+}
+
+// allowed are the options a ConfigMap may set, with the memcached argument
+// of each.
+var allowed = map[string]option{
+	"threads":            {"-t", integer},
+	"conn-limit":         {"-c", integer},
+	"max-item-size":      {"-I", size},
+	"max-reqs-per-event": {"-R", integer},
+	"listen-backlog":     {"-b", integer},
+	"slab-min-size":      {"-n", integer},
+	"slab-growth-factor": {"-f", factor},
+	"disable-cas":        {"-C", flag},
+	"disable-evictions":  {"-M", flag},
+	"large-memory-pages": {"-L", flag},
+}
+
+var sizePattern = regexp.MustCompile(`^[1-9][0-9]*[kKmM]?$`)
+
+// Allowed returns the names of the options a ConfigMap may set, sorted.
+func Allowed() []string {
+	var names []string
+	for name := range allowed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Args returns the memcached arguments of the options in data, ordered by
+// option name so the same options always give the same arguments. Unknown
+// options and invalid values are reported together.
+func Args(data map[string]string) ([]string, error) {
+	var names []string
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args, problems []string
+	for _, name := range names {
+		value := strings.TrimSpace(data[name])
+		o, ok := allowed[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown option %q", name))
+			continue
+		}
+		if err := o.check(value); err != nil {
+			problems = append(problems, fmt.Sprintf("option %q: %v", name, err))
+			continue
+		}
+		switch {
+		case o.kind != flag:
+			args = append(args, o.arg, value)
+		case value == "true":
+			args = append(args, o.arg)
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s (allowed options are %s)", strings.Join(problems, ", "), strings.Join(Allowed(), ", "))
+	}
+	return args, nil
+}
+
+// check returns an error if value is not valid for the option.
+func (o option) check(value string) error {
+	switch o.kind {
+	case flag:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+	case integer:
+		if n, err := strconv.ParseInt(value, 10, 32); err != nil || n <= 0 {
+			return fmt.Errorf("%q is not a positive integer", value)
+		}
+	case size:
+		if !sizePattern.MatchString(value) {
+			return fmt.Errorf("%q is not a size such as 2048, 512k or 2m", value)
+		}
+	case factor:
+		if f, err := strconv.ParseFloat(value, 64); err != nil || f <= 1 {
+			return fmt.Errorf("%q is not a number greater than 1", value)
+		}
+	}
+	return nil
+}
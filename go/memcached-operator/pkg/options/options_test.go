@@ -0,0 +1,72 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		data map[string]string
+		want []string
+		err  string
+	}{
+		{name: "empty", data: nil, want: nil},
+		{
+			name: "sorted by name",
+			data: map[string]string{"threads": "8", "conn-limit": "4096", "max-item-size": "2m"},
+			want: []string{"-c", "4096", "-I", "2m", "-t", "8"},
+		},
+		{
+			name: "flags",
+			data: map[string]string{"disable-cas": "true", "disable-evictions": "false"},
+			want: []string{"-C"},
+		},
+		{name: "trimmed", data: map[string]string{"slab-growth-factor": " 1.5\n"}, want: []string{"-f", "1.5"}},
+		{name: "unknown", data: map[string]string{"port": "11212"}, err: `unknown option "port"`},
+		{name: "not an integer", data: map[string]string{"threads": "many"}, err: `option "threads": "many" is not a positive integer`},
+		{name: "zero", data: map[string]string{"conn-limit": "0"}, err: "is not a positive integer"},
+		{name: "not a size", data: map[string]string{"max-item-size": "1g"}, err: "is not a size"},
+		{name: "small factor", data: map[string]string{"slab-growth-factor": "1"}, err: "is not a number greater than 1"},
+		{name: "not a flag", data: map[string]string{"disable-cas": "yes"}, err: "is not true or false"},
+		{
+			name: "every problem",
+			data: map[string]string{"user": "root", "threads": "-1"},
+			err:  `option "threads": "-1" is not a positive integer, unknown option "user" (allowed options are conn-limit,`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := Args(tt.data)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plan computes the changes applying objects would make to the live
+// objects of a cluster, so they can be reported instead of applied.
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Action is what applying an object would do.
+type Action string
+
+const (
+	Create Action = "create"
+	Update Action = "update"
+	Delete Action = "delete"
+)
+
+// maxValueLength is the length beyond which the values of a field change are
+// truncated.
+const maxValueLength = 80
+
+// FieldChange is a field whose value would change. From is empty for a field
+// that would be added.
+type FieldChange struct {
+	Path string
+	From string
+	To   string
+}
+
+func (f FieldChange) String() string {
+	if f.From == "" {
+		return fmt.Sprintf("%s: %s", f.Path, f.To)
+	}
+	return fmt.Sprintf("%s: %s -> %s", f.Path, f.From, f.To)
+}
+
+// Change is an object that would be created, updated or deleted.
+type Change struct {
+	Action Action
+	Kind   string
+	Name   string
+	// Fields are the fields an update would change, sorted by path.
+	Fields []FieldChange
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s %s", c.Action, c.Kind, c.Name)
+}
+
+// Plan is the list of changes a reconcile would make, in the order it would
+// make them.
+type Plan struct {
+	Changes []Change
+}
+
+// Add appends c to the plan, unless it is nil.
+func (p *Plan) Add(c *Change) {
+	if c != nil {
+		p.Changes = append(p.Changes, *c)
+	}
+}
+
+func (p *Plan) String() string {
+	var b strings.Builder
+	for _, c := range p.Changes {
+		fmt.Fprintln(&b, c)
+		for _, f := range c.Fields {
+			fmt.Fprintf(&b, "  %s\n", f)
+		}
+	}
+	return b.String()
+}
+
+// Diff returns the change applying desired would make to live, both being
+// objects converted to unstructured content, or nil if live already has the
+// desired content. A nil live object is created.
+//
+// Like with server-side apply, only the fields set in desired are compared,
+// so fields defaulted by the API server or set by other writers do not show
+// as changes. The status and null fields are ignored, as they are not applied.
+func Diff(kind, name string, desired, live map[string]interface{}) *Change {
+	if live == nil {
+		return &Change{Action: Create, Kind: kind, Name: name}
+	}
+	desired = withoutStatus(desired)
+	var fields []FieldChange
+	diff("", desired, live, &fields)
+	if len(fields) == 0 {
+		return nil
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Path < fields[j].Path })
+	return &Change{Action: Update, Kind: kind, Name: name, Fields: fields}
+}
+
+// Removal returns the change of deleting an object.
+func Removal(kind, name string) *Change {
+	return &Change{Action: Delete, Kind: kind, Name: name}
+}
+
+func withoutStatus(obj map[string]interface{}) map[string]interface{} {
+	if _, ok := obj["status"]; !ok {
+		return obj
+	}
+	out := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		if k != "status" {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// diff appends to fields the fields of desired, at path, that differ in live.
+func diff(path string, desired, live interface{}, fields *[]FieldChange) {
+	switch d := desired.(type) {
+	case nil:
+		return
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for k, v := range d {
+			diff(join(path, k), v, l[k], fields)
+		}
+		return
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			break
+		}
+		for i := range d {
+			var item interface{}
+			if i < len(l) {
+				item = l[i]
+			}
+			diff(fmt.Sprintf("%s[%d]", path, i), d[i], item, fields)
+		}
+		for i := len(d); i < len(l); i++ {
+			*fields = append(*fields, FieldChange{Path: fmt.Sprintf("%s[%d]", path, i), From: format(l[i]), To: "null"})
+		}
+		return
+	default:
+		if reflect.DeepEqual(normalize(desired), normalize(live)) {
+			return
+		}
+	}
+	// The whole value changes, or is added when live is nil.
+	change := FieldChange{Path: path, To: format(desired)}
+	if live != nil {
+		change.From = format(live)
+	}
+	*fields = append(*fields, change)
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// normalize makes numbers of different types comparable.
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return v
+}
+
+// format returns v as JSON, truncated to maxValueLength.
+func format(v interface{}) string {
+	b, err := json.Marshal(v)
+	s := string(b)
+	if err != nil {
+		s = fmt.Sprint(v)
+	}
+	if len(s) > maxValueLength {
+		s = s[:maxValueLength-3] + "..."
+	}
+	return s
+}
@@ -0,0 +1,88 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func readObject(t *testing.T, path string) map[string]interface{} {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &obj); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return obj
+}
+
+// TestDiffGolden plans applying the desired.yaml object of every directory of
+// testdata to its live.yaml object, absent when the object does not exist,
+// and compares the plan to plan.golden. Run with -update to rewrite the
+// golden files.
+func TestDiffGolden(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("testdata", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		desired := readObject(t, filepath.Join(dir, "desired.yaml"))
+		live := readObject(t, filepath.Join(dir, "live.yaml"))
+		metadata := desired["metadata"].(map[string]interface{})
+
+		p := &Plan{}
+		p.Add(Diff(desired["kind"].(string), metadata["name"].(string), desired, live))
+		got := p.String()
+
+		golden := filepath.Join(dir, "plan.golden")
+		if *update {
+			if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s: plan differs from %s\ngot:\n%s\nwant:\n%s", dir, golden, got, want)
+		}
+	}
+}
+
+func TestRemoval(t *testing.T) {
+	p := &Plan{}
+	p.Add(Removal("Service", "cache-mcrouter"))
+	p.Add(nil)
+	if got, want := p.String(), "delete Service cache-mcrouter\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package policy enforces the MemcachedPolicy limits on the Memcached
+// resources of a namespace.
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/resources"
+)
+
+// Usage is what Memcached resources request from a namespace.
+type Usage struct {
+	Instances int32
+	// Replicas counts the autoscaling maxReplicas of autoscaled resources, so
+	// the autoscaler cannot break a policy.
+	Replicas int32
+	// Memory is the memory of all the memcached pods, in bytes.
+	Memory int64
+}
+
+// UsageOf returns what a Memcached requests.
+func UsageOf(m *cachev1alpha1.Memcached) Usage {
+	replicas := m.Spec.Size
+	if a := m.Spec.Autoscaling; a != nil && a.MaxReplicas > replicas {
+		replicas = a.MaxReplicas
+	}
+	return Usage{
+		Instances: 1,
+		Replicas:  replicas,
+		Memory:    int64(replicas) * resources.MemoryMegabytes(m) << 20,
+	}
+}
+
+func (u Usage) add(o Usage) Usage {
+	return Usage{Instances: u.Instances + o.Instances, Replicas: u.Replicas + o.Replicas, Memory: u.Memory + o.Memory}
+}
+
+// Images returns the images the pods of a Memcached run, sorted.
+func Images(m *cachev1alpha1.Memcached) ([]string, error) {
+	dep, err := resources.MemcachedDeployment(m, nil)
+	if err != nil {
+		return nil, err
+	}
+	pods := []corev1.PodSpec{dep.Spec.Template.Spec}
+	if resources.RouterEnabled(m) {
+		pods = append(pods, resources.McrouterDeployment(m).Spec.Template.Spec)
+	}
+	seen := map[string]bool{}
+	var images []string
+	for _, pod := range pods {
+		for _, c := range append(pod.InitContainers, pod.Containers...) {
+			if !seen[c.Image] {
+				seen[c.Image] = true
+				images = append(images, c.Image)
+			}
+		}
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// Allowed returns whether an image matches one of the patterns of
+// MemcachedPolicySpec.AllowedImages.
+func Allowed(image string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if p == image || (strings.HasSuffix(p, "*") && strings.HasPrefix(image, strings.TrimSuffix(p, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error describing every limit of the policies that m
+// breaks, in a namespace where others are the other Memcached resources.
+// old is the previous version of m on an update, or nil. A namespace already
+// over a limit, for instance after a policy was tightened, only has the
+// updates increasing its usage rejected.
+func Check(policies []cachev1alpha1.MemcachedPolicy, others []cachev1alpha1.Memcached, m, old *cachev1alpha1.Memcached) error {
+	var used Usage
+	for i := range others {
+		used = used.add(UsageOf(&others[i]))
+	}
+	requested := UsageOf(m)
+	total := used.add(requested)
+	var previous Usage
+	if old != nil {
+		previous = UsageOf(old)
+	}
+
+	images, err := Images(m)
+	if err != nil {
+		return err
+	}
+	oldImages := map[string]bool{}
+	if old != nil {
+		if previousImages, err := Images(old); err == nil {
+			for _, image := range previousImages {
+				oldImages[image] = true
+			}
+		}
+	}
+
+	var denials []string
+	deny := func(p *cachev1alpha1.MemcachedPolicy, format string, args ...interface{}) {
+		denials = append(denials, fmt.Sprintf("MemcachedPolicy %s: ", p.Name)+fmt.Sprintf(format, args...))
+	}
+	for i := range policies {
+		p := &policies[i]
+		if max := p.Spec.MaxInstances; max != nil && total.Instances > *max && requested.Instances > previous.Instances {
+			deny(p, "namespace %s would have %d Memcached instances, more than the %d allowed",
+				m.Namespace, total.Instances, *max)
+		}
+		if max := p.Spec.MaxReplicas; max != nil && total.Replicas > *max && requested.Replicas > previous.Replicas {
+			deny(p, "namespace %s would run %d memcached replicas, more than the %d allowed",
+				m.Namespace, total.Replicas, *max)
+		}
+		if max := p.Spec.MaxMemory; max != nil && total.Memory > max.Value() && requested.Memory > previous.Memory {
+			deny(p, "namespace %s would use %s of memcached memory, more than the %s allowed",
+				m.Namespace, resource.NewQuantity(total.Memory, resource.BinarySI), max)
+		}
+		for _, image := range images {
+			if !oldImages[image] && !Allowed(image, p.Spec.AllowedImages) {
+				deny(p, "image %s is not allowed, allowed images are %s",
+					image, strings.Join(p.Spec.AllowedImages, ", "))
+			}
+		}
+	}
+	if len(denials) > 0 {
+		return fmt.Errorf("%s", strings.Join(denials, "; "))
+	}
+	return nil
+}
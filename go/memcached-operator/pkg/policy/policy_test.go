@@ -0,0 +1,161 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+)
+
+func int32Ptr(n int32) *int32 { return &n }
+
+func quantity(s string) *resource.Quantity {
+	q := resource.MustParse(s)
+	return &q
+}
+
+func memcached(name string, size int32, memory string) cachev1alpha1.Memcached {
+	m := cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team"},
+		Spec:       cachev1alpha1.MemcachedSpec{Size: size},
+	}
+	if memory != "" {
+		m.Spec.Memory = quantity(memory)
+	}
+	return m
+}
+
+func memcachedPolicy(spec cachev1alpha1.MemcachedPolicySpec) cachev1alpha1.MemcachedPolicy {
+	return cachev1alpha1.MemcachedPolicy{ObjectMeta: metav1.ObjectMeta{Name: "quota"}, Spec: spec}
+}
+
+func TestUsageOf(t *testing.T) {
+	m := memcached("a", 3, "128Mi")
+	if got := UsageOf(&m); got != (Usage{Instances: 1, Replicas: 3, Memory: 3 * 128 << 20}) {
+		t.Errorf("unexpected usage %+v", got)
+	}
+	m.Spec.Autoscaling = &cachev1alpha1.AutoscalingSpec{MinReplicas: 1, MaxReplicas: 7}
+	if got := UsageOf(&m); got.Replicas != 7 || got.Memory != 7*128<<20 {
+		t.Errorf("expected autoscaled instances to count their maximum, got %+v", got)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	patterns := []string{"memcached:1.6", "registry.example.com/*"}
+	for image, want := range map[string]bool{
+		"memcached:1.6":                   true,
+		"memcached:1.5":                   false,
+		"registry.example.com/mcrouter:1": true,
+		"docker.io/registry.example.com":  false,
+	} {
+		if got := Allowed(image, patterns); got != want {
+			t.Errorf("%s: expected %v, got %v", image, want, got)
+		}
+	}
+	if !Allowed("anything", nil) {
+		t.Error("expected any image to be allowed without patterns")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	others := []cachev1alpha1.Memcached{memcached("a", 3, ""), memcached("b", 5, "128Mi")}
+	routed := memcached("c", 1, "")
+	routed.Spec.Router = &cachev1alpha1.RouterSpec{Enabled: true, Image: "mcrouter/mcrouter:v0.41"}
+	tests := []struct {
+		name   string
+		policy cachev1alpha1.MemcachedPolicySpec
+		m      cachev1alpha1.Memcached
+		old    *cachev1alpha1.Memcached
+		err    string
+	}{
+		{name: "no limits", m: memcached("c", 99, "")},
+		{name: "instances", policy: cachev1alpha1.MemcachedPolicySpec{MaxInstances: int32Ptr(3)}, m: memcached("c", 1, "")},
+		{
+			name:   "too many instances",
+			policy: cachev1alpha1.MemcachedPolicySpec{MaxInstances: int32Ptr(2)},
+			m:      memcached("c", 1, ""),
+			err:    "MemcachedPolicy quota: namespace team would have 3 Memcached instances, more than the 2 allowed",
+		},
+		{
+			name:   "too many replicas",
+			policy: cachev1alpha1.MemcachedPolicySpec{MaxReplicas: int32Ptr(10)},
+			m:      memcached("c", 3, ""),
+			err:    "namespace team would run 11 memcached replicas, more than the 10 allowed",
+		},
+		{
+			name:   "too much memory",
+			policy: cachev1alpha1.MemcachedPolicySpec{MaxMemory: quantity("1Gi")},
+			m:      memcached("c", 3, "128Mi"),
+			err:    "namespace team would use 1216Mi of memcached memory, more than the 1Gi allowed",
+		},
+		{
+			name:   "memory",
+			policy: cachev1alpha1.MemcachedPolicySpec{MaxMemory: quantity("2Gi")},
+			m:      memcached("c", 3, "128Mi"),
+		},
+		{
+			name:   "image",
+			policy: cachev1alpha1.MemcachedPolicySpec{AllowedImages: []string{"memcached:*", "mcrouter/*"}},
+			m:      routed,
+		},
+		{
+			name:   "router image",
+			policy: cachev1alpha1.MemcachedPolicySpec{AllowedImages: []string{"memcached:*"}},
+			m:      routed,
+			err:    "image mcrouter/mcrouter:v0.41 is not allowed, allowed images are memcached:*",
+		},
+		{
+			name:   "update within an exceeded limit",
+			policy: cachev1alpha1.MemcachedPolicySpec{MaxInstances: int32Ptr(1), MaxReplicas: int32Ptr(5)},
+			m:      memcached("c", 3, "64Mi"),
+			old:    func() *cachev1alpha1.Memcached { m := memcached("c", 5, ""); return &m }(),
+		},
+		{
+			name:   "update exceeding a limit",
+			policy: cachev1alpha1.MemcachedPolicySpec{MaxReplicas: int32Ptr(10)},
+			m:      memcached("c", 5, ""),
+			old:    func() *cachev1alpha1.Memcached { m := memcached("c", 1, ""); return &m }(),
+			err:    "would run 13 memcached replicas",
+		},
+		{
+			name: "every denial",
+			policy: cachev1alpha1.MemcachedPolicySpec{
+				MaxInstances:  int32Ptr(2),
+				AllowedImages: []string{"memcached:1.6"},
+			},
+			m:   memcached("c", 1, ""),
+			err: "more than the 2 allowed; MemcachedPolicy quota: image memcached:1.4.36-alpine is not allowed",
+		},
+	}
+	for _, tt := range tests {
+		err := Check([]cachev1alpha1.MemcachedPolicy{memcachedPolicy(tt.policy)}, others, &tt.m, tt.old)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected the Memcached to be allowed, got %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"context"
+	"net/http"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/class"
+)
+
+// WebhookPath is the path the Validator is served at.
+const WebhookPath = "/validate-cache-example-com-v1alpha1-memcached-policy"
+
+var log = logf.Log.WithName("memcached-policy")
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-cache-example-com-v1alpha1-memcached-policy,mutating=false,failurePolicy=fail,groups=cache.example.com,resources=memcacheds,versions=v1alpha1,name=vmemcachedpolicy.kb.io
+
+// +kubebuilder:rbac:groups=cache.example.com,resources=memcachedpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cache.example.com,resources=memcachedclasses,verbs=get;list;watch
+
+// Validator is the admission webhook rejecting the Memcached resources that
+// break a MemcachedPolicy. Unlike the webhooks of the Memcached type, it
+// reads the policies and the other Memcached resources of the namespace.
+//
+// The limits are best-effort: two Memcached resources admitted at the same
+// time do not see each other, and may together break a policy.
+type Validator struct {
+	Client client.Reader
+	// APIReader lists the other Memcached resources of the namespace from the
+	// API server, so those created since the cache was last updated are
+	// counted. Client is used when it is nil.
+	APIReader client.Reader
+	Decoder   *admission.Decoder
+}
+
+var _ admission.Handler = &Validator{}
+
+// SetupWebhookWithManager serves a Validator reading the policies and classes
+// from the cache of mgr, and the Memcached resources from the API server.
+func SetupWebhookWithManager(mgr ctrl.Manager) error {
+	decoder, err := admission.NewDecoder(mgr.GetScheme())
+	if err != nil {
+		return err
+	}
+	mgr.GetWebhookServer().Register(WebhookPath, &webhook.Admission{
+		Handler: &Validator{Client: mgr.GetClient(), APIReader: mgr.GetAPIReader(), Decoder: decoder},
+	})
+	return nil
+}
+
+// Handle implements admission.Handler.
+func (v *Validator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	m := &cachev1alpha1.Memcached{}
+	if err := v.Decoder.Decode(req, m); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	var old *cachev1alpha1.Memcached
+	if req.Operation == admissionv1beta1.Update {
+		old = &cachev1alpha1.Memcached{}
+		if err := v.Decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	policies := &cachev1alpha1.MemcachedPolicyList{}
+	if err := v.Client.List(ctx, policies); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if len(policies.Items) == 0 {
+		return admission.Allowed("")
+	}
+	reader := v.APIReader
+	if reader == nil {
+		reader = v.Client
+	}
+	list := &cachev1alpha1.MemcachedList{}
+	if err := reader.List(ctx, list, client.InNamespace(req.Namespace)); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	classes := &cachev1alpha1.MemcachedClassList{}
+	if err := v.Client.List(ctx, classes); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	var others []cachev1alpha1.Memcached
+	for i := range list.Items {
+		if list.Items[i].Name != req.Name {
+			others = append(others, *withClass(classes.Items, &list.Items[i]))
+		}
+	}
+
+	if m.Namespace == "" {
+		m.Namespace = req.Namespace
+	}
+	if old != nil {
+		old = withClass(classes.Items, old)
+	}
+	if err := Check(policies.Items, others, withClass(classes.Items, m), old); err != nil {
+		log.Info("Denied Memcached", "memcached", req.Name, "namespace", req.Namespace, "reason", err.Error())
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
+
+// withClass returns m with the presets of its MemcachedClass applied, as the
+// images and memory of the class count against the policies. A Memcached
+// whose class is missing is checked as is, since it is not deployed until
+// the class is created.
+func withClass(classes []cachev1alpha1.MemcachedClass, m *cachev1alpha1.Memcached) *cachev1alpha1.Memcached {
+	c, err := class.Select(classes, m)
+	if err != nil {
+		return m
+	}
+	effective, err := class.Apply(m, c)
+	if err != nil {
+		return m
+	}
+	return effective
+}
@@ -0,0 +1,158 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+)
+
+func newScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := cachev1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func newValidator(t *testing.T, objs ...runtime.Object) *Validator {
+	scheme := newScheme(t)
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Validator{Client: fake.NewFakeClientWithScheme(scheme, objs...), Decoder: decoder}
+}
+
+func request(t *testing.T, operation admissionv1beta1.Operation, m, old *cachev1alpha1.Memcached) admission.Request {
+	raw := func(m *cachev1alpha1.Memcached) runtime.RawExtension {
+		if m == nil {
+			return runtime.RawExtension{}
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return runtime.RawExtension{Raw: data}
+	}
+	return admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+		Operation: operation,
+		Name:      m.Name,
+		Namespace: m.Namespace,
+		Object:    raw(m),
+		OldObject: raw(old),
+	}}
+}
+
+func TestValidatorSumsTheNamespace(t *testing.T) {
+	existing := memcached("a", 5, "")
+	elsewhere := memcached("b", 5, "")
+	elsewhere.Namespace = "other"
+	quota := memcachedPolicy(cachev1alpha1.MemcachedPolicySpec{MaxReplicas: int32Ptr(8)})
+	v := newValidator(t, &existing, &elsewhere, &quota)
+
+	small, large := memcached("c", 3, ""), memcached("c", 5, "")
+	if resp := v.Handle(context.TODO(), request(t, admissionv1beta1.Create, &small, nil)); !resp.Allowed {
+		t.Errorf("expected the Memcached to fit in the namespace, got %v", resp.Result)
+	}
+	resp := v.Handle(context.TODO(), request(t, admissionv1beta1.Create, &large, nil))
+	if resp.Allowed || !strings.Contains(string(resp.Result.Reason), "namespace team would run 10 memcached replicas, more than the 8 allowed") {
+		t.Errorf("expected the Memcached to be denied, got %v", resp.Result)
+	}
+
+	// The previous version of the updated Memcached is not counted twice.
+	grown := existing.DeepCopy()
+	grown.Spec.Size = 7
+	if resp := v.Handle(context.TODO(), request(t, admissionv1beta1.Update, grown, &existing)); !resp.Allowed {
+		t.Errorf("expected the update to fit in the namespace, got %v", resp.Result)
+	}
+}
+
+func TestValidatorCountsUncachedMemcacheds(t *testing.T) {
+	quota := memcachedPolicy(cachev1alpha1.MemcachedPolicySpec{MaxReplicas: int32Ptr(8)})
+	v := newValidator(t, &quota)
+	live := fake.NewFakeClientWithScheme(newScheme(t), &quota)
+	v.APIReader = live
+
+	first, second := memcached("a", 5, ""), memcached("b", 5, "")
+	if resp := v.Handle(context.TODO(), request(t, admissionv1beta1.Create, &first, nil)); !resp.Allowed {
+		t.Fatalf("expected the first Memcached to be allowed, got %v", resp.Result)
+	}
+	// The first Memcached is created but not in the cache yet.
+	if err := live.Create(context.TODO(), &first); err != nil {
+		t.Fatal(err)
+	}
+	resp := v.Handle(context.TODO(), request(t, admissionv1beta1.Create, &second, nil))
+	if resp.Allowed || !strings.Contains(string(resp.Result.Reason), "namespace team would run 10 memcached replicas, more than the 8 allowed") {
+		t.Errorf("expected the second Memcached to be denied, got %v", resp.Result)
+	}
+}
+
+func TestValidatorAllowsWithoutPolicies(t *testing.T) {
+	v := newValidator(t)
+	m := memcached("a", 99, "")
+	if resp := v.Handle(context.TODO(), request(t, admissionv1beta1.Create, &m, nil)); !resp.Allowed {
+		t.Errorf("expected the Memcached to be allowed, got %v", resp.Result)
+	}
+}
+
+func TestValidatorAppliesClasses(t *testing.T) {
+	preset := cachev1alpha1.MemcachedClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "standard",
+			Annotations: map[string]string{cachev1alpha1.DefaultClassAnnotation: "true"},
+		},
+		Spec: cachev1alpha1.MemcachedClassSpec{Image: "registry.example.com/memcached:1.6", Memory: quantity("1Gi")},
+	}
+	quota := memcachedPolicy(cachev1alpha1.MemcachedPolicySpec{
+		MaxMemory:     quantity("2Gi"),
+		AllowedImages: []string{"memcached:*"},
+	})
+	v := newValidator(t, &preset, &quota)
+
+	m := memcached("a", 3, "")
+	resp := v.Handle(context.TODO(), request(t, admissionv1beta1.Create, &m, nil))
+	if resp.Allowed {
+		t.Fatalf("expected the presets of the default class to be denied, got %v", resp.Result)
+	}
+	for _, want := range []string{"would use 3Gi of memcached memory", "image registry.example.com/memcached:1.6 is not allowed"} {
+		if !strings.Contains(string(resp.Result.Reason), want) {
+			t.Errorf("expected the denial to contain %q, got %q", want, resp.Result.Reason)
+		}
+	}
+
+	// The spec of the Memcached overrides the presets of its class.
+	m.Spec.Memory = quantity("512Mi")
+	m.Spec.PodTemplate = &runtime.RawExtension{Raw: []byte(`{"spec":{"containers":[{"name":"memcached","image":"memcached:1.6"}]}}`)}
+	if resp := v.Handle(context.TODO(), request(t, admissionv1beta1.Create, &m, nil)); !resp.Allowed {
+		t.Errorf("expected the Memcached overriding its class to be allowed, got %v", resp.Result)
+	}
+}
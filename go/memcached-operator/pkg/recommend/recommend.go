@@ -0,0 +1,188 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package recommend sizes the memory and the number of memcached servers from
+// the evictions and memory usage observed over a window of time.
+package recommend
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	mi = 1 << 20
+	gi = 1 << 30
+)
+
+// Sample is the total of the statistics of all the servers at a point in time.
+type Sample struct {
+	Time time.Time
+	// Evictions is the sum of the eviction counters of the servers.
+	Evictions uint64
+	// Bytes is the memory used to store items across the servers.
+	Bytes uint64
+	// LimitMaxBytes is the memory the servers may use in total.
+	LimitMaxBytes uint64
+	// Replicas is the number of servers the sample was taken from.
+	Replicas int32
+}
+
+// Config tunes the recommender. Zero values are replaced by defaults.
+type Config struct {
+	// Window is how far back samples are considered. Defaults to one hour.
+	Window time.Duration
+	// MinHistory is how much of the window must be covered by samples before
+	// a recommendation is made. Defaults to a quarter of the window.
+	MinHistory time.Duration
+	// TargetUtilization is the fraction of the memory the peak usage should
+	// take, leaving room for growth. Defaults to 0.8.
+	TargetUtilization float64
+	// GrowthFactor multiplies the memory when items are evicted, as the
+	// actual working set size cannot be observed then. Defaults to 1.5.
+	GrowthFactor float64
+	// MinMemory and MaxMemory bound the memory of a single server, in bytes.
+	// They default to 64Mi and 8Gi.
+	MinMemory int64
+	MaxMemory int64
+	// Granularity is the multiple the memory of a server is rounded up to.
+	// Defaults to 16Mi.
+	Granularity int64
+}
+
+// Recommendation is the suggested size of a Memcached.
+type Recommendation struct {
+	// Memory is the memory of each server in bytes.
+	Memory int64
+	// Replicas is the number of servers, always odd.
+	Replicas int32
+	Reason   string
+}
+
+// Recommender accumulates samples and derives recommendations from them.
+type Recommender struct {
+	Config Config
+
+	samples []Sample
+}
+
+// Observe records a sample and forgets those that left the window. A sample
+// whose LimitMaxBytes or Replicas differ from the previous one starts a new
+// history, as the earlier samples describe servers that were resized: the
+// evictions that led to a resize must not grow the memory again.
+func (r *Recommender) Observe(s Sample) {
+	if n := len(r.samples); n > 0 && (r.samples[n-1].LimitMaxBytes != s.LimitMaxBytes || r.samples[n-1].Replicas != s.Replicas) {
+		r.samples = r.samples[:0]
+	}
+	r.samples = append(r.samples, s)
+	cfg := r.config()
+	kept := r.samples[:0]
+	for _, old := range r.samples {
+		if s.Time.Sub(old.Time) <= cfg.Window {
+			kept = append(kept, old)
+		}
+	}
+	r.samples = kept
+}
+
+// Recommend returns the recommendation for the samples in the window, or
+// false while the history is too short to be meaningful.
+//
+// When items were evicted during the window, the total memory is grown by
+// GrowthFactor. Otherwise it is sized so that the peak usage reaches
+// TargetUtilization. The total is then split over the current number of
+// servers, adding servers when a single one would need more than MaxMemory.
+func (r *Recommender) Recommend() (Recommendation, bool) {
+	cfg := r.config()
+	if len(r.samples) < 2 {
+		return Recommendation{}, false
+	}
+	first, last := r.samples[0], r.samples[len(r.samples)-1]
+	if last.Time.Sub(first.Time) < cfg.MinHistory || last.LimitMaxBytes == 0 || last.Replicas < 1 {
+		return Recommendation{}, false
+	}
+
+	var evictions uint64
+	var peak uint64
+	for i, s := range r.samples {
+		if s.Bytes > peak {
+			peak = s.Bytes
+		}
+		// Counters restart from zero with the servers.
+		if i > 0 && s.Evictions >= r.samples[i-1].Evictions {
+			evictions += s.Evictions - r.samples[i-1].Evictions
+		}
+	}
+
+	var total float64
+	var reason string
+	if evictions > 0 {
+		total = float64(last.LimitMaxBytes) * cfg.GrowthFactor
+		reason = fmt.Sprintf("%d evictions in the last %s", evictions, last.Time.Sub(first.Time).Round(time.Second))
+	} else {
+		total = float64(peak) / cfg.TargetUtilization
+		reason = fmt.Sprintf("peak usage %dMi of %dMi without evictions", peak/mi, last.LimitMaxBytes/mi)
+	}
+
+	replicas := last.Replicas
+	if perServer := total / float64(replicas); perServer > float64(cfg.MaxMemory) {
+		replicas = int32(math.Ceil(total / float64(cfg.MaxMemory)))
+		if replicas%2 == 0 {
+			replicas++
+		}
+		reason += fmt.Sprintf(", more than %dMi per server", cfg.MaxMemory/mi)
+	}
+	if replicas%2 == 0 {
+		replicas++
+	}
+
+	memory := int64(math.Ceil(total / float64(replicas)))
+	memory = (memory + cfg.Granularity - 1) / cfg.Granularity * cfg.Granularity
+	if memory < cfg.MinMemory {
+		memory = cfg.MinMemory
+	}
+	if memory > cfg.MaxMemory {
+		memory = cfg.MaxMemory
+	}
+	return Recommendation{Memory: memory, Replicas: replicas, Reason: reason}, true
+}
+
+func (r *Recommender) config() Config {
+	cfg := r.Config
+	if cfg.Window == 0 {
+		cfg.Window = time.Hour
+	}
+	if cfg.MinHistory == 0 {
+		cfg.MinHistory = cfg.Window / 4
+	}
+	if cfg.TargetUtilization == 0 {
+		cfg.TargetUtilization = 0.8
+	}
+	if cfg.GrowthFactor == 0 {
+		cfg.GrowthFactor = 1.5
+	}
+	if cfg.MinMemory == 0 {
+		cfg.MinMemory = 64 * mi
+	}
+	if cfg.MaxMemory == 0 {
+		cfg.MaxMemory = 8 * gi
+	}
+	if cfg.Granularity == 0 {
+		cfg.Granularity = 16 * mi
+	}
+	return cfg
+}
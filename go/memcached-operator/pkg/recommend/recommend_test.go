@@ -0,0 +1,176 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package recommend
+
+import (
+	"testing"
+	"time"
+)
+
+// series returns one sample per minute for the given duration, with the
+// memory usage and eviction counter produced by the given functions of the
+// minute index.
+func series(minutes int, replicas int32, limit uint64, bytes func(i int) uint64, evictions func(i int) uint64) []Sample {
+	start := time.Unix(1600000000, 0)
+	var samples []Sample
+	for i := 0; i <= minutes; i++ {
+		samples = append(samples, Sample{
+			Time:          start.Add(time.Duration(i) * time.Minute),
+			Evictions:     evictions(i),
+			Bytes:         bytes(i),
+			LimitMaxBytes: limit,
+			Replicas:      replicas,
+		})
+	}
+	return samples
+}
+
+func constant(v uint64) func(int) uint64 { return func(int) uint64 { return v } }
+
+func TestRecommend(t *testing.T) {
+	tests := []struct {
+		name      string
+		samples   []Sample
+		wantOK    bool
+		wantMem   int64
+		wantRepls int32
+	}{
+		{
+			name:    "not enough history",
+			samples: series(10, 3, 3*64*mi, constant(10*mi), constant(0)),
+		},
+		{
+			name:    "idle cache shrinks to the minimum",
+			samples: series(60, 3, 3*256*mi, constant(30*mi), constant(0)),
+			// 30Mi / 0.8 = 37.5Mi in total, below the 64Mi minimum per server.
+			wantOK: true, wantMem: 64 * mi, wantRepls: 3,
+		},
+		{
+			name: "sized from the peak usage",
+			samples: series(60, 3, 3*1024*mi, func(i int) uint64 {
+				if i == 30 {
+					return 1200 * mi
+				}
+				return 600 * mi
+			}, constant(0)),
+			// 1200Mi / 0.8 = 1500Mi over 3 servers = 500Mi, rounded up to 512Mi.
+			wantOK: true, wantMem: 512 * mi, wantRepls: 3,
+		},
+		{
+			name: "evictions grow the memory",
+			samples: series(60, 3, 3*64*mi, constant(3*64*mi), func(i int) uint64 {
+				return uint64(i * 10)
+			}),
+			// 192Mi * 1.5 = 288Mi over 3 servers = 96Mi.
+			wantOK: true, wantMem: 96 * mi, wantRepls: 3,
+		},
+		{
+			name: "eviction counter reset is not an eviction",
+			samples: series(60, 3, 3*256*mi, constant(3*100*mi), func(i int) uint64 {
+				if i < 30 {
+					return 500
+				}
+				return 0
+			}),
+			// 300Mi / 0.8 = 375Mi over 3 servers = 125Mi, rounded up to 128Mi.
+			wantOK: true, wantMem: 128 * mi, wantRepls: 3,
+		},
+		{
+			name: "large caches get more servers",
+			samples: series(60, 1, 8*gi, constant(8*gi), func(i int) uint64 {
+				return uint64(i)
+			}),
+			// 8Gi * 1.5 = 12Gi does not fit a single 8Gi server: 2 servers,
+			// rounded to 3 of 4Gi each.
+			wantOK: true, wantMem: 4 * gi, wantRepls: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Recommender{}
+			for _, s := range tt.samples {
+				r.Observe(s)
+			}
+			got, ok := r.Recommend()
+			if ok != tt.wantOK {
+				t.Fatalf("got ok=%v, want %v (%+v)", ok, tt.wantOK, got)
+			}
+			if !ok {
+				return
+			}
+			if got.Memory != tt.wantMem || got.Replicas != tt.wantRepls {
+				t.Errorf("got %dMi x %d, want %dMi x %d (%s)", got.Memory/mi, got.Replicas, tt.wantMem/mi, tt.wantRepls, got.Reason)
+			}
+		})
+	}
+}
+
+func TestObserveForgetsSamplesOutsideTheWindow(t *testing.T) {
+	r := &Recommender{Config: Config{Window: 30 * time.Minute}}
+	// Evictions during the first half hour only.
+	for _, s := range series(90, 3, 3*64*mi, constant(3*32*mi), func(i int) uint64 {
+		if i < 30 {
+			return uint64(i)
+		}
+		return 30
+	}) {
+		r.Observe(s)
+	}
+	got, ok := r.Recommend()
+	if !ok {
+		t.Fatal("expected a recommendation")
+	}
+	// 96Mi / 0.8 = 120Mi over 3 servers = 40Mi, below the 64Mi minimum.
+	if got.Memory != 64*mi {
+		t.Errorf("expected old evictions to be forgotten, got %dMi (%s)", got.Memory/mi, got.Reason)
+	}
+}
+
+func TestResizeStartsANewHistory(t *testing.T) {
+	r := &Recommender{}
+	// Evictions on 3 servers of 64Mi.
+	for _, s := range series(60, 3, 3*64*mi, constant(3*64*mi), func(i int) uint64 {
+		return uint64(i * 10)
+	}) {
+		r.Observe(s)
+	}
+	got, ok := r.Recommend()
+	if !ok || got.Memory != 96*mi {
+		t.Fatalf("expected the evictions to grow the memory to 96Mi, got %dMi (%s)", got.Memory/mi, got.Reason)
+	}
+
+	// The servers are resized to 96Mi and stop evicting. The evictions
+	// before the resize are still within the window.
+	start := time.Unix(1600000000, 0).Add(61 * time.Minute)
+	for i := 0; i <= 20; i++ {
+		r.Observe(Sample{
+			Time:          start.Add(time.Duration(i) * time.Minute),
+			Evictions:     600,
+			Bytes:         3 * 64 * mi,
+			LimitMaxBytes: 3 * 96 * mi,
+			Replicas:      3,
+		})
+	}
+	got, ok = r.Recommend()
+	if !ok {
+		t.Fatal("expected a recommendation")
+	}
+	// 192Mi / 0.8 = 240Mi over 3 servers = 80Mi.
+	if got.Memory != 80*mi {
+		t.Errorf("expected the memory to stop growing after the resize, got %dMi (%s)", got.Memory/mi, got.Reason)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/mcrouter"
+)
+
+const (
+	// McrouterDefaultImage is the image of mcrouter when the spec does not
+	// set one.
+	McrouterDefaultImage = "mcrouter/mcrouter:v0.41.0"
+
+	// McrouterPort is the port mcrouter listens on.
+	McrouterPort = 5000
+
+	mcrouterConfigKey = "config.json"
+	mcrouterConfigDir = "/etc/mcrouter"
+)
+
+// RouterEnabled returns whether m asks for an mcrouter front-end.
+func RouterEnabled(m *cachev1alpha1.Memcached) bool {
+	return m.Spec.Router != nil && m.Spec.Router.Enabled
+}
+
+// McrouterName returns the name of the mcrouter resources of m.
+func McrouterName(m *cachev1alpha1.Memcached) string {
+	return m.Name + "-mcrouter"
+}
+
+// McrouterLabels returns the labels for selecting the mcrouter resources
+// belonging to the given memcached CR name.
+func McrouterLabels(name string) map[string]string {
+	return map[string]string{cachev1alpha1.AppLabel: "mcrouter", MemcachedLabel: name}
+}
+
+// McrouterConfigMap returns the ConfigMap holding the mcrouter configuration
+// of m for the given pool of memcached addresses.
+func McrouterConfigMap(m *cachev1alpha1.Memcached, pool []string) (*corev1.ConfigMap, error) {
+	config, err := mcrouter.Marshal(mcrouter.Mode(m.Spec.Router.Mode), pool)
+	if err != nil {
+		return nil, err
+	}
+	return &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: objectMeta(m, McrouterName(m), McrouterLabels(m.Name)),
+		Data:       map[string]string{mcrouterConfigKey: string(config)},
+	}, nil
+}
+
+// McrouterDeployment returns the mcrouter Deployment of m.
+func McrouterDeployment(m *cachev1alpha1.Memcached) *appsv1.Deployment {
+	ls := McrouterLabels(m.Name)
+	replicas := int32(1)
+	if m.Spec.Router.Replicas != nil {
+		replicas = *m.Spec.Router.Replicas
+	}
+	image := m.Spec.Router.Image
+	if image == "" {
+		image = McrouterDefaultImage
+	}
+
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: objectMeta(m, McrouterName(m), ls),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: ls,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Image: image,
+						Name:  "mcrouter",
+						Command: []string{"mcrouter",
+							fmt.Sprintf("--port=%d", McrouterPort),
+							fmt.Sprintf("--config-file=%s/%s", mcrouterConfigDir, mcrouterConfigKey),
+						},
+						Ports: []corev1.ContainerPort{{
+							ContainerPort: McrouterPort,
+							Name:          "mcrouter",
+						}},
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "config",
+							MountPath: mcrouterConfigDir,
+						}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "config",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{Name: McrouterName(m)},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// McrouterService returns the Service clients use to reach the mcrouter of m.
+func McrouterService(m *cachev1alpha1.Memcached) *corev1.Service {
+	ls := McrouterLabels(m.Name)
+	return &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: objectMeta(m, McrouterName(m), ls),
+		Spec: corev1.ServiceSpec{
+			Selector: ls,
+			Ports: []corev1.ServicePort{{
+				Name:       "mcrouter",
+				Port:       McrouterPort,
+				TargetPort: intstr.FromString("mcrouter"),
+			}},
+		},
+	}
+}
@@ -0,0 +1,251 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resources builds the objects owned by a Memcached. The functions
+// only depend on their arguments, so the reconciler, the render command and
+// tests get the same objects for the same Memcached.
+package resources
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/discovery"
+)
+
+const (
+	// MemcachedLabel is set on the objects of a Memcached and of its mcrouter
+	// front-end, holding the name of the Memcached.
+	MemcachedLabel = cachev1alpha1.MemcachedLabel
+
+	// TemplateHashAnnotation is set on the pod template of the memcached
+	// Deployment, holding the hash of the rest of the template.
+	TemplateHashAnnotation = "cache.example.com/template-hash"
+
+	// MemcachedImage is the image of the memcached containers.
+	MemcachedImage = "memcached:1.4.36-alpine"
+
+	// MemcachedPort is the port memcached listens on.
+	MemcachedPort = 11211
+
+	// DefaultMemory is the memory of a memcached pod when the spec does not
+	// set it.
+	DefaultMemory = 64 << 20
+
+	// EndpointsUpdatedAnnotation records when the endpoints were last published.
+	EndpointsUpdatedAnnotation = "cache.example.com/endpoints-updated-at"
+)
+
+// All returns the objects the reconciler applies for m while none of its pods
+// is ready, in the order it applies them, with the extra memcached arguments
+// of its configRef. The endpoints have no publication time, so the objects
+// only depend on the arguments.
+func All(m *cachev1alpha1.Memcached, args []string) ([]runtime.Object, error) {
+	dep, err := MemcachedDeployment(m, args)
+	if err != nil {
+		return nil, err
+	}
+	objs := []runtime.Object{dep}
+	if RouterEnabled(m) {
+		cm, err := McrouterConfigMap(m, nil)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, cm, McrouterDeployment(m), McrouterService(m))
+	}
+	endpoints, err := EndpointsConfigMap(m, nil, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return append(objs, endpoints), nil
+}
+
+// MemcachedLabels returns the labels for selecting the resources belonging
+// to the given memcached CR name.
+func MemcachedLabels(name string) map[string]string {
+	return map[string]string{cachev1alpha1.AppLabel: "memcached", MemcachedLabel: name}
+}
+
+// MemoryMegabytes returns the memory of each memcached pod in megabytes, as
+// expected by the -m option, rounding up.
+func MemoryMegabytes(m *cachev1alpha1.Memcached) int64 {
+	bytes := int64(DefaultMemory)
+	if m.Spec.Memory != nil {
+		bytes = m.Spec.Memory.Value()
+	}
+	return (bytes + 1<<20 - 1) >> 20
+}
+
+// MemcachedDeployment returns the memcached Deployment of m, with the
+// security context of its profile and its podTemplate merged onto the pod
+// template. The extra arguments, read from the configRef of m with
+// options.Args, are passed after those set from the spec.
+func MemcachedDeployment(m *cachev1alpha1.Memcached, args []string) (*appsv1.Deployment, error) {
+	ls := MemcachedLabels(m.Name)
+	replicas := m.Spec.Size
+	command := append([]string{"memcached", fmt.Sprintf("-m=%d", MemoryMegabytes(m)), "-o", "modern", "-v"}, args...)
+
+	dep := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: objectMeta(m, m.Name, ls),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: ls,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Image:   MemcachedImage,
+						Name:    "memcached",
+						Command: command,
+						Ports: []corev1.ContainerPort{{
+							ContainerPort: MemcachedPort,
+							Name:          "memcached",
+						}},
+					}},
+				},
+			},
+		},
+	}
+	if Restricted(m) {
+		restrictPodTemplate(&dep.Spec.Template)
+	}
+	if m.Spec.PodTemplate != nil {
+		template, err := patchPodTemplate(dep.Spec.Template, m.Spec.PodTemplate.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid podTemplate: %v", err)
+		}
+		if Restricted(m) {
+			restrictSidecars(template)
+		}
+		dep.Spec.Template = *template
+	}
+	if err := setTemplateHash(&dep.Spec.Template); err != nil {
+		return nil, err
+	}
+	return dep, nil
+}
+
+// setTemplateHash annotates template with the hash of its content, so a
+// change of any of its fields can be told from the annotation of the
+// Deployment found in the cluster, whose template the API server defaults.
+func setTemplateHash(template *corev1.PodTemplateSpec) error {
+	data, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[TemplateHashAnnotation] = hex.EncodeToString(sum[:8])
+	return nil
+}
+
+// patchPodTemplate merges a strategic merge patch onto template. The labels
+// and the memcached command of template are set again on the result, so the
+// patch cannot make the Deployment lose its pods or change how memcached is
+// configured, and the memcached container is kept first.
+func patchPodTemplate(template corev1.PodTemplateSpec, patch []byte) (*corev1.PodTemplateSpec, error) {
+	original, err := json.Marshal(template)
+	if err != nil {
+		return nil, err
+	}
+	data, err := strategicpatch.StrategicMergePatch(original, patch, corev1.PodTemplateSpec{})
+	if err != nil {
+		return nil, err
+	}
+	patched := &corev1.PodTemplateSpec{}
+	if err := json.Unmarshal(data, patched); err != nil {
+		return nil, err
+	}
+
+	if patched.Labels == nil {
+		patched.Labels = map[string]string{}
+	}
+	for k, v := range template.Labels {
+		patched.Labels[k] = v
+	}
+	memcached := template.Spec.Containers[0]
+	containers := patched.Spec.Containers
+	for i, c := range containers {
+		if c.Name == memcached.Name {
+			c.Command, c.Args = memcached.Command, nil
+			copy(containers[1:i+1], containers[:i])
+			containers[0] = c
+			return patched, nil
+		}
+	}
+	return nil, fmt.Errorf("the %s container was removed", memcached.Name)
+}
+
+// EndpointsName returns the name of the client discovery ConfigMap of m.
+func EndpointsName(m *cachev1alpha1.Memcached) string {
+	return m.Name + "-endpoints"
+}
+
+// EndpointsConfigMap returns the client discovery ConfigMap of m publishing
+// endpoints at the given time, which is not recorded when zero.
+func EndpointsConfigMap(m *cachev1alpha1.Memcached, endpoints []discovery.Endpoint, now time.Time) (*corev1.ConfigMap, error) {
+	data, err := discovery.Encode(endpoints)
+	if err != nil {
+		return nil, err
+	}
+	cm := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: objectMeta(m, EndpointsName(m), MemcachedLabels(m.Name)),
+		Data:       data,
+	}
+	if !now.IsZero() {
+		cm.Annotations = map[string]string{
+			EndpointsUpdatedAnnotation: now.UTC().Format(time.RFC3339),
+		}
+	}
+	return cm, nil
+}
+
+// PodNames returns the names of the given pods.
+func PodNames(pods []corev1.Pod) []string {
+	var podNames []string
+	for _, pod := range pods {
+		podNames = append(podNames, pod.Name)
+	}
+	return podNames
+}
+
+// objectMeta returns the metadata of an object of m, which m controls.
+func objectMeta(m *cachev1alpha1.Memcached, name string, labels map[string]string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:            name,
+		Namespace:       m.Namespace,
+		Labels:          labels,
+		OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(m, cachev1alpha1.GroupVersion.WithKind("Memcached"))},
+	}
+}
@@ -0,0 +1,287 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+	"github.com/example/memcached-operator/pkg/discovery"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// TestAllGolden builds the objects of the Memcached of every YAML file of
+// testdata and compares them to the golden file of the same name. Run with
+// -update to rewrite the golden files.
+func TestAllGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range inputs {
+		data, err := ioutil.ReadFile(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := &cachev1alpha1.Memcached{}
+		if err := yaml.Unmarshal(data, m); err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		objs, err := All(m, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		var got bytes.Buffer
+		for i, obj := range objs {
+			if i > 0 {
+				got.WriteString("---\n")
+			}
+			out, err := yaml.Marshal(obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got.Write(out)
+		}
+
+		golden := strings.TrimSuffix(input, ".yaml") + ".golden"
+		if *update {
+			if err := ioutil.WriteFile(golden, got.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != string(want) {
+			t.Errorf("%s: objects differ from %s\ngot:\n%s\nwant:\n%s", input, golden, got.String(), want)
+		}
+	}
+}
+
+func TestMemoryMegabytes(t *testing.T) {
+	for _, tt := range []struct {
+		memory string
+		want   int64
+	}{
+		{"", 64},
+		{"64Mi", 64},
+		{"1Gi", 1024},
+		{"1G", 954},
+		{"100M", 96},
+		{"1", 1},
+	} {
+		m := &cachev1alpha1.Memcached{}
+		if tt.memory != "" {
+			q := resource.MustParse(tt.memory)
+			m.Spec.Memory = &q
+		}
+		if got := MemoryMegabytes(m); got != tt.want {
+			t.Errorf("memory %q: expected %dMi, got %dMi", tt.memory, tt.want, got)
+		}
+	}
+}
+
+func TestMemcachedDeploymentArgs(t *testing.T) {
+	m := &cachev1alpha1.Memcached{ObjectMeta: metav1.ObjectMeta{Name: "cache"}}
+	dep, err := MemcachedDeployment(m, []string{"-t", "8", "-C"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"memcached", "-m=64", "-o", "modern", "-v", "-t", "8", "-C"}
+	if got := dep.Spec.Template.Spec.Containers[0].Command; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the extra arguments after the spec ones, got %v", got)
+	}
+}
+
+func TestMemcachedDeploymentPodTemplate(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		check func(*corev1.PodTemplateSpec) bool
+		err   string
+	}{
+		{
+			name:  "sidecar",
+			patch: `{"spec":{"containers":[{"name":"exporter","image":"exporter"}]}}`,
+			check: func(p *corev1.PodTemplateSpec) bool {
+				return len(p.Spec.Containers) == 2 && p.Spec.Containers[0].Image == MemcachedImage
+			},
+		},
+		{
+			name:  "selector labels kept",
+			patch: `{"metadata":{"labels":{"app":"other","$patch":"replace"}}}`,
+			check: func(p *corev1.PodTemplateSpec) bool {
+				return reflect.DeepEqual(p.Labels, MemcachedLabels("cache"))
+			},
+		},
+		{
+			name:  "command kept",
+			patch: `{"spec":{"containers":[{"name":"memcached","command":["sh"],"args":["-c"]}]}}`,
+			check: func(p *corev1.PodTemplateSpec) bool {
+				c := p.Spec.Containers[0]
+				return c.Command[0] == "memcached" && c.Args == nil
+			},
+		},
+		{
+			name:  "memcached container removed",
+			patch: `{"spec":{"containers":[{"name":"memcached","$patch":"delete"}]}}`,
+			err:   "invalid podTemplate: the memcached container was removed",
+		},
+		{
+			name:  "not a patch",
+			patch: `[]`,
+			err:   "invalid podTemplate",
+		},
+	}
+	for _, tt := range tests {
+		m := &cachev1alpha1.Memcached{
+			ObjectMeta: metav1.ObjectMeta{Name: "cache"},
+			Spec:       cachev1alpha1.MemcachedSpec{PodTemplate: &runtime.RawExtension{Raw: []byte(tt.patch)}},
+		}
+		dep, err := MemcachedDeployment(m, nil)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !tt.check(&dep.Spec.Template) {
+			t.Errorf("%s: unexpected pod template %+v", tt.name, dep.Spec.Template)
+		}
+	}
+}
+
+// TestWebhookProtectsSelectorLabels checks that the webhook rejects the
+// overrides of every selector label of the memcached pods.
+func TestWebhookProtectsSelectorLabels(t *testing.T) {
+	for label := range MemcachedLabels("cache") {
+		m := &cachev1alpha1.Memcached{Spec: cachev1alpha1.MemcachedSpec{
+			Size:        3,
+			PodTemplate: &runtime.RawExtension{Raw: []byte(`{"metadata":{"labels":{"` + label + `":"other"}}}`)},
+		}}
+		if err := m.ValidateCreate(); err == nil {
+			t.Errorf("expected the override of label %s to be rejected", label)
+		}
+	}
+}
+
+func TestOwnedByMemcached(t *testing.T) {
+	m := &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "ns", UID: "uid"},
+		Spec: cachev1alpha1.MemcachedSpec{
+			Size:   3,
+			Router: &cachev1alpha1.RouterSpec{Enabled: true},
+		},
+	}
+	objs, err := All(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 5 {
+		t.Fatalf("expected the memcached and mcrouter objects, got %d objects", len(objs))
+	}
+	for _, obj := range objs {
+		meta := obj.(metav1.Object)
+		if meta.GetNamespace() != "ns" {
+			t.Errorf("%s: expected the namespace of the Memcached, got %q", meta.GetName(), meta.GetNamespace())
+		}
+		ref := metav1.GetControllerOf(meta)
+		if ref == nil || ref.Kind != "Memcached" || ref.Name != "cache" || ref.UID != "uid" {
+			t.Errorf("%s: expected the Memcached as controller, got %+v", meta.GetName(), ref)
+		}
+		if meta.GetLabels()[MemcachedLabel] != "cache" {
+			t.Errorf("%s: expected the %s label, got %v", meta.GetName(), MemcachedLabel, meta.GetLabels())
+		}
+	}
+}
+
+func TestEndpointsConfigMap(t *testing.T) {
+	m := &cachev1alpha1.Memcached{ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "ns"}}
+	endpoints := []discovery.Endpoint{{Pod: "cache-a", Address: "10.0.0.1:11211"}}
+	now := time.Date(2020, 8, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	for _, tt := range []struct {
+		now  time.Time
+		want map[string]string
+	}{
+		{now, map[string]string{EndpointsUpdatedAnnotation: "2020-08-01T10:00:00Z"}},
+		{time.Time{}, nil},
+	} {
+		cm, err := EndpointsConfigMap(m, endpoints, tt.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cm.Name != "cache-endpoints" {
+			t.Errorf("unexpected name %q", cm.Name)
+		}
+		if !reflect.DeepEqual(cm.Annotations, tt.want) {
+			t.Errorf("at %v: expected annotations %v, got %v", tt.now, tt.want, cm.Annotations)
+		}
+		if cm.Data[discovery.ServersKey] != "10.0.0.1:11211" {
+			t.Errorf("expected the endpoints in the data, got %v", cm.Data)
+		}
+	}
+}
+
+func TestPodNames(t *testing.T) {
+	for _, tt := range []struct {
+		pods []corev1.Pod
+		want []string
+	}{
+		{nil, nil},
+		{[]corev1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "a"}}, {ObjectMeta: metav1.ObjectMeta{Name: "b"}}}, []string{"a", "b"}},
+	} {
+		if got := PodNames(tt.pods); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expected %v, got %v", tt.want, got)
+		}
+	}
+}
+
+// The builders return typed objects, which must convert to the unstructured
+// content applied with server-side apply.
+func TestConvertible(t *testing.T) {
+	m := &cachev1alpha1.Memcached{ObjectMeta: metav1.ObjectMeta{Name: "cache"}, Spec: cachev1alpha1.MemcachedSpec{Size: 1}}
+	objs, err := All(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objs {
+		if _, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+)
+
+// MemcachedUser is the uid and gid of the memcache user of the memcached
+// image, which restricted pods run as.
+const MemcachedUser = 11211
+
+// Restricted returns whether the memcached pods of m follow the restricted
+// Pod Security Standard.
+func Restricted(m *cachev1alpha1.Memcached) bool {
+	return m.Spec.SecurityProfile != cachev1alpha1.SecurityProfileUnrestricted
+}
+
+// restrictPodTemplate sets the security context of the restricted profile on
+// a memcached pod template. memcached only needs its port and memory, so it
+// runs as the memcache user of the image with a read-only root filesystem
+// and no capabilities. The seccomp profile is set with the pod annotation, as
+// the API version the operator is built with has no seccompProfile field.
+func restrictPodTemplate(template *corev1.PodTemplateSpec) {
+	user := int64(MemcachedUser)
+	nonRoot := true
+	noToken := false
+
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[corev1.SeccompPodAnnotationKey] = corev1.SeccompProfileRuntimeDefault
+	template.Spec.AutomountServiceAccountToken = &noToken
+	template.Spec.SecurityContext = &corev1.PodSecurityContext{
+		RunAsNonRoot: &nonRoot,
+		RunAsUser:    &user,
+		RunAsGroup:   &user,
+	}
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].SecurityContext = restrictedContext(true)
+	}
+}
+
+// restrictSidecars sets the security context of the restricted profile on
+// the containers added by the podTemplate of a Memcached without one, so the
+// pods stay restricted. Their root filesystem is left writable, which the
+// restricted profile does not require.
+func restrictSidecars(template *corev1.PodTemplateSpec) {
+	for _, containers := range [][]corev1.Container{template.Spec.InitContainers, template.Spec.Containers} {
+		for i := range containers {
+			if containers[i].SecurityContext == nil {
+				containers[i].SecurityContext = restrictedContext(false)
+			}
+		}
+	}
+}
+
+func restrictedContext(readOnlyRootFilesystem bool) *corev1.SecurityContext {
+	noEscalation := false
+	sc := &corev1.SecurityContext{
+		AllowPrivilegeEscalation: &noEscalation,
+		Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+	}
+	if readOnlyRootFilesystem {
+		sc.ReadOnlyRootFilesystem = &readOnlyRootFilesystem
+	}
+	return sc
+}
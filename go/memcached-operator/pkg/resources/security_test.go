@@ -0,0 +1,224 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	cachev1alpha1 "github.com/example/memcached-operator/api/v1alpha1"
+)
+
+// restrictedVolumes are the volume types the restricted profile allows.
+var restrictedVolumes = map[string]bool{
+	"configMap": true, "csi": true, "downwardAPI": true, "emptyDir": true,
+	"ephemeral": true, "persistentVolumeClaim": true, "projected": true, "secret": true,
+}
+
+// restrictedViolations returns the rules of the restricted Pod Security
+// Standard the pod template breaks, which include those of the baseline
+// profile.
+func restrictedViolations(template corev1.PodTemplateSpec) []string {
+	var violations []string
+	violate := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+	spec := template.Spec
+	if spec.HostNetwork || spec.HostPID || spec.HostIPC {
+		violate("host namespaces are shared")
+	}
+	for _, v := range spec.Volumes {
+		volume, err := volumeFields(v.VolumeSource)
+		if err != nil {
+			violate("volume %s: %v", v.Name, err)
+		}
+		for kind := range volume {
+			if !restrictedVolumes[kind] {
+				violate("volume %s is of type %s", v.Name, kind)
+			}
+		}
+	}
+
+	pod := spec.SecurityContext
+	if pod == nil {
+		pod = &corev1.PodSecurityContext{}
+	}
+	podSeccomp := template.Annotations[corev1.SeccompPodAnnotationKey]
+	for _, c := range append(append([]corev1.Container(nil), spec.InitContainers...), spec.Containers...) {
+		sc := c.SecurityContext
+		if sc == nil {
+			sc = &corev1.SecurityContext{}
+		}
+		if sc.Privileged != nil && *sc.Privileged {
+			violate("container %s is privileged", c.Name)
+		}
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			violate("container %s allows privilege escalation", c.Name)
+		}
+		if sc.Capabilities == nil || !dropsAll(sc.Capabilities.Drop) {
+			violate("container %s does not drop all capabilities", c.Name)
+		}
+		if sc.Capabilities != nil {
+			for _, capability := range sc.Capabilities.Add {
+				if capability != "NET_BIND_SERVICE" {
+					violate("container %s adds capability %s", c.Name, capability)
+				}
+			}
+		}
+		nonRoot := sc.RunAsNonRoot
+		if nonRoot == nil {
+			nonRoot = pod.RunAsNonRoot
+		}
+		if nonRoot == nil || !*nonRoot {
+			violate("container %s may run as root", c.Name)
+		}
+		user := sc.RunAsUser
+		if user == nil {
+			user = pod.RunAsUser
+		}
+		if user != nil && *user == 0 {
+			violate("container %s runs as uid 0", c.Name)
+		}
+		if sc.ProcMount != nil && *sc.ProcMount != corev1.DefaultProcMount {
+			violate("container %s unmasks /proc", c.Name)
+		}
+		seccomp := template.Annotations[corev1.SeccompContainerAnnotationKeyPrefix+c.Name]
+		if seccomp == "" {
+			seccomp = podSeccomp
+		}
+		if seccomp != corev1.SeccompProfileRuntimeDefault && seccomp != corev1.DeprecatedSeccompProfileDockerDefault &&
+			!strings.HasPrefix(seccomp, "localhost/") {
+			violate("container %s has no seccomp profile", c.Name)
+		}
+		for _, p := range c.Ports {
+			if p.HostPort != 0 {
+				violate("container %s uses host port %d", c.Name, p.HostPort)
+			}
+		}
+	}
+	return violations
+}
+
+func dropsAll(capabilities []corev1.Capability) bool {
+	for _, c := range capabilities {
+		if c == "ALL" {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeFields returns the fields set on a volume source, keyed by type.
+func volumeFields(source corev1.VolumeSource) (map[string]interface{}, error) {
+	data, err := yaml.Marshal(source)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	return fields, yaml.Unmarshal(data, &fields)
+}
+
+// TestRestrictedPodSecurity checks that the memcached pods of every Memcached
+// of testdata follow the restricted Pod Security Standard.
+func TestRestrictedPodSecurity(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range inputs {
+		data, err := ioutil.ReadFile(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := &cachev1alpha1.Memcached{}
+		if err := yaml.Unmarshal(data, m); err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		dep, err := MemcachedDeployment(m, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		if violations := restrictedViolations(dep.Spec.Template); len(violations) > 0 {
+			t.Errorf("%s: expected restricted pods, got %s", input, strings.Join(violations, ", "))
+		}
+		if automount := dep.Spec.Template.Spec.AutomountServiceAccountToken; automount == nil || *automount {
+			t.Errorf("%s: expected no service account token", input)
+		}
+	}
+}
+
+func TestUnrestrictedPodSecurity(t *testing.T) {
+	m := &cachev1alpha1.Memcached{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache"},
+		Spec:       cachev1alpha1.MemcachedSpec{SecurityProfile: cachev1alpha1.SecurityProfileUnrestricted},
+	}
+	dep, err := MemcachedDeployment(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := dep.Spec.Template
+	if template.Spec.SecurityContext != nil || template.Spec.Containers[0].SecurityContext != nil || template.Annotations[corev1.SeccompPodAnnotationKey] != "" {
+		t.Errorf("expected the defaults of the image, got %+v", template)
+	}
+	if len(restrictedViolations(template)) == 0 {
+		t.Error("expected the unrestricted pods to break the restricted profile")
+	}
+}
+
+func TestRestrictedViolations(t *testing.T) {
+	m := &cachev1alpha1.Memcached{ObjectMeta: metav1.ObjectMeta{Name: "cache"}}
+	dep, err := MemcachedDeployment(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		change func(*corev1.PodTemplateSpec)
+		want   string
+	}{
+		{"host network", func(p *corev1.PodTemplateSpec) { p.Spec.HostNetwork = true }, "host namespaces are shared"},
+		{"host path", func(p *corev1.PodTemplateSpec) {
+			p.Spec.Volumes = []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}}}}
+		}, "volume data is of type hostPath"},
+		{"root", func(p *corev1.PodTemplateSpec) {
+			root := int64(0)
+			p.Spec.Containers[0].SecurityContext.RunAsUser = &root
+		}, "container memcached runs as uid 0"},
+		{"capability", func(p *corev1.PodTemplateSpec) {
+			p.Spec.Containers[0].SecurityContext.Capabilities.Add = []corev1.Capability{"SYS_ADMIN"}
+		}, "container memcached adds capability SYS_ADMIN"},
+		{"seccomp", func(p *corev1.PodTemplateSpec) { p.Annotations = nil }, "container memcached has no seccomp profile"},
+		{"sidecar", func(p *corev1.PodTemplateSpec) {
+			p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Name: "sidecar"})
+		}, "container sidecar allows privilege escalation"},
+	}
+	for _, tt := range tests {
+		template := dep.Spec.Template.DeepCopy()
+		tt.change(template)
+		violations := strings.Join(restrictedViolations(*template), ", ")
+		if !strings.Contains(violations, tt.want) {
+			t.Errorf("%s: expected a violation %q, got %q", tt.name, tt.want, violations)
+		}
+	}
+}
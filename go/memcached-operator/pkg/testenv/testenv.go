@@ -0,0 +1,67 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testenv locates the control plane binaries of the envtest suites,
+// so they run against binaries available locally instead of downloading them.
+package testenv
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// AssetsEnv is the environment variable envtest reads the directory of its
+// binaries from.
+const AssetsEnv = "KUBEBUILDER_ASSETS"
+
+// binaries are the binaries envtest starts.
+var binaries = []string{"kube-apiserver", "etcd"}
+
+// Setup points envtest to the first directory holding its binaries among
+// KUBEBUILDER_ASSETS, the testbin/bin directory of the module at root, where
+// make test installs them, and the default /usr/local/kubebuilder/bin. It
+// returns the directory, or an empty string when none has the binaries.
+func Setup(root string) string {
+	dirs := []string{
+		os.Getenv(AssetsEnv),
+		filepath.Join(root, "testbin", "bin"),
+		"/usr/local/kubebuilder/bin",
+	}
+	for _, dir := range dirs {
+		if dir == "" || !hasBinaries(dir) {
+			continue
+		}
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		if err := os.Setenv(AssetsEnv, dir); err != nil {
+			continue
+		}
+		return dir
+	}
+	return ""
+}
+
+func hasBinaries(dir string) bool {
+	for _, b := range binaries {
+		info, err := os.Stat(filepath.Join(dir, b))
+		if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeBinaries(t *testing.T, dir string, mode os.FileMode) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range binaries {
+		path := filepath.Join(dir, b)
+		if err := ioutil.WriteFile(path, nil, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSetup(t *testing.T) {
+	root, err := ioutil.TempDir("", "testenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer os.Setenv(AssetsEnv, os.Getenv(AssetsEnv))
+
+	assets := filepath.Join(root, "assets")
+	testbin := filepath.Join(root, "testbin", "bin")
+	writeBinaries(t, assets, 0755)
+	writeBinaries(t, testbin, 0644)
+
+	os.Setenv(AssetsEnv, assets)
+	if got := Setup(root); got != assets {
+		t.Errorf("expected the binaries of %s to be used, got %q", AssetsEnv, got)
+	}
+
+	// Binaries that cannot be executed are skipped.
+	os.Setenv(AssetsEnv, filepath.Join(root, "missing"))
+	if got := Setup(root); got == testbin {
+		t.Errorf("expected non-executable binaries to be skipped")
+	}
+
+	writeBinaries(t, testbin, 0755)
+	if got := Setup(root); got != testbin {
+		t.Errorf("expected the binaries of testbin to be used, got %q", got)
+	}
+	if got := os.Getenv(AssetsEnv); got != testbin {
+		t.Errorf("expected %s to point to testbin, got %q", AssetsEnv, got)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package warmup snapshots the keys held by a set of memcached servers and
+// replays their values into freshly started servers.
+package warmup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/example/memcached-operator/pkg/memcache"
+)
+
+// DefaultMaxKeys is the number of keys captured when the spec does not set a limit.
+const DefaultMaxKeys = 10000
+
+// DefaultTimeout bounds each connection and request made to a memcached server.
+const DefaultTimeout = 2 * time.Second
+
+// Snapshot is the set of keys held by the servers before a rollout.
+type Snapshot struct {
+	// Keys are the deduplicated keys found on the source servers.
+	Keys []memcache.KeyInfo
+	// Sources are the names of the pods the snapshot was taken from.
+	Sources map[string]bool
+	// Warmed are the names of the pods the snapshot has been replayed into.
+	Warmed map[string]bool
+	// Taken is when the snapshot was taken.
+	Taken time.Time
+}
+
+// Progress is the outcome of replaying a snapshot into a single server.
+type Progress struct {
+	Warmed  int32
+	Skipped int32
+}
+
+// Warmer takes snapshots and replays them.
+type Warmer struct {
+	// MaxKeys limits the size of a snapshot. Defaults to DefaultMaxKeys.
+	MaxKeys int
+	// Timeout bounds every connection and request. Defaults to DefaultTimeout.
+	Timeout time.Duration
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Take dumps the keys of every server in addrs, keyed by pod name.
+// Servers that cannot be reached are left out of Sources.
+func (w *Warmer) Take(addrs map[string]string) (*Snapshot, error) {
+	snap := &Snapshot{Sources: map[string]bool{}, Warmed: map[string]bool{}, Taken: w.now()}
+	seen := map[string]bool{}
+	var lastErr error
+	for pod, addr := range addrs {
+		keys, err := w.dump(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		snap.Sources[pod] = true
+		for _, key := range keys {
+			if seen[key.Key] || len(snap.Keys) >= w.maxKeys() {
+				continue
+			}
+			seen[key.Key] = true
+			snap.Keys = append(snap.Keys, key)
+		}
+	}
+	if len(snap.Sources) == 0 && lastErr != nil {
+		return nil, fmt.Errorf("unable to snapshot any server: %v", lastErr)
+	}
+	return snap, nil
+}
+
+// Replay copies every key of the snapshot into target, fetching the values from
+// the first peer that still holds them. Keys that have expired or that no peer
+// holds any more are counted as skipped. It stops with the error of ctx once
+// ctx is done.
+func (w *Warmer) Replay(ctx context.Context, snap *Snapshot, target string, peers []string) (Progress, error) {
+	var progress Progress
+	dst, err := memcache.Dial(target, w.timeout())
+	if err != nil {
+		return progress, err
+	}
+	defer dst.Close()
+
+	var srcs []*memcache.Client
+	for _, peer := range peers {
+		src, err := memcache.Dial(peer, w.timeout())
+		if err != nil {
+			continue
+		}
+		defer src.Close()
+		srcs = append(srcs, src)
+	}
+
+	now := w.now().Unix()
+	for _, key := range snap.Keys {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+		if key.Expiration > 0 && key.Expiration <= now {
+			progress.Skipped++
+			continue
+		}
+		item := fetch(srcs, key.Key)
+		if item == nil {
+			progress.Skipped++
+			continue
+		}
+		// Absolute timestamps are understood by memcached as long as they are
+		// more than 30 days in the future of the epoch, which they always are.
+		item.Expiration = 0
+		if key.Expiration > 0 {
+			item.Expiration = key.Expiration
+		}
+		if err := dst.Set(item); err != nil {
+			return progress, err
+		}
+		progress.Warmed++
+	}
+	return progress, nil
+}
+
+func (w *Warmer) dump(addr string) ([]memcache.KeyInfo, error) {
+	c, err := memcache.Dial(addr, w.timeout())
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.MetaDump(w.maxKeys())
+}
+
+// fetch returns the value of key from the first client that holds it.
+func fetch(srcs []*memcache.Client, key string) *memcache.Item {
+	for _, src := range srcs {
+		item, err := src.Get(key)
+		if err == nil {
+			return item
+		}
+	}
+	return nil
+}
+
+func (w *Warmer) maxKeys() int {
+	if w.MaxKeys > 0 {
+		return w.MaxKeys
+	}
+	return DefaultMaxKeys
+}
+
+func (w *Warmer) timeout() time.Duration {
+	if w.Timeout > 0 {
+		return w.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (w *Warmer) now() time.Time {
+	if w.Now != nil {
+		return w.Now()
+	}
+	return time.Now()
+}
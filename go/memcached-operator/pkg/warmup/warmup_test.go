@@ -0,0 +1,183 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/example/memcached-operator/pkg/memcache"
+	"github.com/example/memcached-operator/pkg/memcache/memcachetest"
+)
+
+func item(key, value string) memcache.Item {
+	return memcache.Item{Key: key, Value: []byte(value)}
+}
+
+func TestTakeDeduplicatesAndLimitsKeys(t *testing.T) {
+	a := memcachetest.NewServer(item("a", "1"), item("shared", "x"))
+	defer a.Close()
+	b := memcachetest.NewServer(item("b", "2"), item("shared", "x"))
+	defer b.Close()
+
+	w := &Warmer{}
+	snap, err := w.Take(map[string]string{"pod-a": a.Addr, "pod-b": b.Addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Keys) != 3 {
+		t.Errorf("expected 3 keys, got %d: %v", len(snap.Keys), snap.Keys)
+	}
+	if !snap.Sources["pod-a"] || !snap.Sources["pod-b"] {
+		t.Errorf("expected both pods as sources, got %v", snap.Sources)
+	}
+
+	w.MaxKeys = 1
+	snap, err = w.Take(map[string]string{"pod-a": a.Addr, "pod-b": b.Addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Keys) != 1 {
+		t.Errorf("expected MaxKeys to limit the snapshot to 1 key, got %d", len(snap.Keys))
+	}
+}
+
+func TestTakeSkipsUnreachableServers(t *testing.T) {
+	a := memcachetest.NewServer(item("a", "1"))
+	defer a.Close()
+	down := memcachetest.NewServer()
+	down.Close()
+
+	snap, err := (&Warmer{Timeout: time.Second}).Take(map[string]string{"pod-a": a.Addr, "pod-down": down.Addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Sources["pod-down"] {
+		t.Error("expected the unreachable pod not to be a source")
+	}
+
+	if _, err := (&Warmer{Timeout: time.Second}).Take(map[string]string{"pod-down": down.Addr}); err == nil {
+		t.Error("expected an error when no server can be reached")
+	}
+}
+
+func TestTakeSkipsFaultyServers(t *testing.T) {
+	a := memcachetest.NewServer(item("a", "1"))
+	defer a.Close()
+	garbled := memcachetest.NewServer(item("g", "1"))
+	defer garbled.Close()
+	garbled.SetFaults(memcachetest.Faults{Garble: true, Commands: []string{"lru_crawler"}})
+	dropped := memcachetest.NewServer(item("d", "1"))
+	defer dropped.Close()
+	dropped.SetFaults(memcachetest.Faults{Drop: true})
+
+	snap, err := (&Warmer{Timeout: time.Second}).Take(map[string]string{
+		"pod-a":       a.Addr,
+		"pod-garbled": garbled.Addr,
+		"pod-dropped": dropped.Addr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !snap.Sources["pod-a"] || snap.Sources["pod-garbled"] || snap.Sources["pod-dropped"] {
+		t.Errorf("expected only the healthy pod as source, got %v", snap.Sources)
+	}
+	if len(snap.Keys) != 1 {
+		t.Errorf("expected the keys of the healthy pod, got %v", snap.Keys)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	old := memcachetest.NewServer(
+		memcache.Item{Key: "kept", Value: []byte("v1"), Flags: 7},
+		memcache.Item{Key: "expiring", Value: []byte("v2"), Expiration: now.Unix() + 60},
+		memcache.Item{Key: "expired", Value: []byte("v3"), Expiration: now.Unix() - 1},
+		item("evicted", "v4"),
+	)
+	defer old.Close()
+	w := &Warmer{Now: func() time.Time { return now }}
+	snap, err := w.Take(map[string]string{"old": old.Addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Evicted between the snapshot and the replay.
+	old.Delete("evicted")
+
+	fresh := memcachetest.NewServer()
+	defer fresh.Close()
+	progress, err := w.Replay(context.TODO(), snap, fresh.Addr, []string{old.Addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Warmed != 2 || progress.Skipped != 2 {
+		t.Errorf("expected 2 warmed and 2 skipped keys, got %+v", progress)
+	}
+	if got := fresh.Keys(); !equal(got, []string{"expiring", "kept"}) {
+		t.Errorf("unexpected keys on the new server: %v", got)
+	}
+	if e, _ := fresh.Get("kept"); string(e.Value) != "v1" || e.Flags != 7 || e.Expiration != 0 {
+		t.Errorf("unexpected replayed entry %+v", e)
+	}
+	if e, _ := fresh.Get("expiring"); e.Expiration != now.Unix()+60 {
+		t.Errorf("expected the absolute expiry to be kept, got %d", e.Expiration)
+	}
+}
+
+func TestReplayFailsWhenTargetIsDown(t *testing.T) {
+	down := memcachetest.NewServer()
+	down.Close()
+	snap := &Snapshot{}
+	if _, err := (&Warmer{Timeout: time.Second}).Replay(context.TODO(), snap, down.Addr, nil); err == nil {
+		t.Error("expected an error when the target cannot be reached")
+	}
+}
+
+func TestReplayStopsWhenContextIsDone(t *testing.T) {
+	old := memcachetest.NewServer(item("a", "v1"), item("b", "v2"))
+	defer old.Close()
+	w := &Warmer{}
+	snap, err := w.Take(map[string]string{"old": old.Addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh := memcachetest.NewServer()
+	defer fresh.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	progress, err := w.Replay(ctx, snap, fresh.Addr, []string{old.Addr})
+	if err != context.Canceled {
+		t.Errorf("expected the replay to be cancelled, got %v", err)
+	}
+	if progress.Warmed != 0 || len(fresh.Keys()) != 0 {
+		t.Errorf("expected no key to be replayed, got %+v and %v", progress, fresh.Keys())
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}